@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// aclAssertion describes an ACL entry that is expected to exist in the cluster
+type aclAssertion struct {
+	Principal    string `json:"principal"`
+	Operation    string `json:"operation"`
+	ResourceType string `json:"resourceType"`
+	ResourceName string `json:"resourceName"`
+}
+
+func (a aclAssertion) String() string {
+	return fmt.Sprintf("%s:%s:%s:%s", a.Principal, a.Operation, a.ResourceType, a.ResourceName)
+}
+
+var aclOperations = map[string]sarama.AclOperation{
+	"all":             sarama.AclOperationAll,
+	"read":            sarama.AclOperationRead,
+	"write":           sarama.AclOperationWrite,
+	"create":          sarama.AclOperationCreate,
+	"delete":          sarama.AclOperationDelete,
+	"alter":           sarama.AclOperationAlter,
+	"describe":        sarama.AclOperationDescribe,
+	"clusteraction":   sarama.AclOperationClusterAction,
+	"describeconfigs": sarama.AclOperationDescribeConfigs,
+	"alterconfigs":    sarama.AclOperationAlterConfigs,
+	"idempotentwrite": sarama.AclOperationIdempotentWrite,
+}
+
+var aclResourceTypes = map[string]sarama.AclResourceType{
+	"topic":           sarama.AclResourceTopic,
+	"group":           sarama.AclResourceGroup,
+	"cluster":         sarama.AclResourceCluster,
+	"transactionalid": sarama.AclResourceTransactionalID,
+}
+
+// parseACLAssertions parses a comma separated list of ACL assertions. Each
+// assertion is written as principal:operation:resourceType:resourceName, where
+// the principal includes its type, ex: User:alice:Read:Topic:orders
+func parseACLAssertions(s string) ([]aclAssertion, error) {
+	var assertions []aclAssertion
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 5)
+		if len(parts) != 5 {
+			return nil, fmt.Errorf("invalid ACL assertion %q, expected principalType:principal:operation:resourceType:resourceName", item)
+		}
+		a := aclAssertion{
+			Principal:    parts[0] + ":" + parts[1],
+			Operation:    parts[2],
+			ResourceType: parts[3],
+			ResourceName: parts[4],
+		}
+		if _, ok := aclOperations[strings.ToLower(a.Operation)]; !ok {
+			return nil, fmt.Errorf("invalid ACL operation %q in %q", a.Operation, item)
+		}
+		if _, ok := aclResourceTypes[strings.ToLower(a.ResourceType)]; !ok {
+			return nil, fmt.Errorf("invalid ACL resource type %q in %q", a.ResourceType, item)
+		}
+		assertions = append(assertions, a)
+	}
+	return assertions, nil
+}
+
+// checkACLs lists all the ACLs of the cluster and returns the assertions that
+// are not satisfied. An assertion is satisfied when an ALLOW entry matches it
+// and no DENY entry does. Wildcard principals (User:*) and resources (*) are
+// honored, as well as the All operation
+func checkACLs(admin sarama.ClusterAdmin, assertions []aclAssertion) ([]aclAssertion, error) {
+	acls, err := admin.ListAcls(sarama.AclFilter{
+		ResourceType:   sarama.AclResourceAny,
+		Operation:      sarama.AclOperationAny,
+		PermissionType: sarama.AclPermissionAny,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []aclAssertion
+	for _, a := range assertions {
+		allowed, denied := false, false
+		for _, res := range acls {
+			if !a.matchResource(res.Resource) {
+				continue
+			}
+			for _, acl := range res.Acls {
+				if !a.matchACL(acl) {
+					continue
+				}
+				switch acl.PermissionType {
+				case sarama.AclPermissionAllow:
+					allowed = true
+				case sarama.AclPermissionDeny:
+					denied = true
+				}
+			}
+		}
+		if !allowed || denied {
+			missing = append(missing, a)
+		}
+	}
+	return missing, nil
+}
+
+func (a aclAssertion) matchResource(r sarama.Resource) bool {
+	return r.ResourceType == aclResourceTypes[strings.ToLower(a.ResourceType)] &&
+		(r.ResourceName == a.ResourceName || r.ResourceName == "*")
+}
+
+func (a aclAssertion) matchACL(acl *sarama.Acl) bool {
+	op := aclOperations[strings.ToLower(a.Operation)]
+	return (acl.Principal == a.Principal || acl.Principal == "User:*") &&
+		(acl.Operation == op || acl.Operation == sarama.AclOperationAll)
+}
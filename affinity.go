@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"sort"
+)
+
+// colocatedReplicas returns the replicas of a partition sharing a failure
+// domain, by domain: the rack of the brokers, as rack:name, and their host, as
+// host:name. The replicas on a broker that is not live are ignored, as their
+// location is unknown
+func colocatedReplicas(state *clusterState, replicas []int32) map[string][]int32 {
+	domains := make(map[string][]int32)
+	for _, id := range replicas {
+		b, ok := state.Brokers[id]
+		if !ok {
+			continue
+		}
+		if b.Rack != "" {
+			domains["rack:"+b.Rack] = append(domains["rack:"+b.Rack], id)
+		}
+		host, _, err := net.SplitHostPort(b.Addr)
+		if err != nil {
+			host = b.Addr
+		}
+		domains["host:"+host] = append(domains["host:"+host], id)
+	}
+	for domain, ids := range domains {
+		if len(ids) < 2 {
+			delete(domains, domain)
+			continue
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return domains
+}
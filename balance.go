@@ -0,0 +1,156 @@
+package main
+
+import "github.com/prune998/kafka-health/health"
+
+// replicaBalance reports how evenly the replicas are spread over the live
+// brokers, as the ratio of the highest number of replicas on a broker to the
+// mean. A ratio of 1 is a perfect balance, a broker added to the cluster
+// without a reassignment pulls the mean down and the ratio up
+type replicaBalance struct {
+	Replicas map[int32]int `json:"replicas"` // number of replicas by broker
+	Max      int           `json:"max"`      // highest number of replicas on a broker
+	Mean     float64       `json:"mean"`     // mean number of replicas by broker
+	Ratio    float64       `json:"ratio"`    // Max / Mean
+	MaxRatio float64       `json:"maxRatio"` // ratio above which the check fails
+	Failed   bool          `json:"failed"`   // Ratio exceeds MaxRatio
+}
+
+// computeReplicaBalance counts the replicas of each live broker, across all
+// the topics of the snapshot. The excluded brokers are left out
+func computeReplicaBalance(state *clusterState, excluded []int32, maxRatio float64) *replicaBalance {
+	b := &replicaBalance{
+		Replicas: make(map[int32]int, len(state.Brokers)),
+		MaxRatio: maxRatio,
+	}
+	for id := range state.Brokers {
+		if !containsBroker(excluded, id) {
+			b.Replicas[id] = 0
+		}
+	}
+	for _, ts := range state.Topics {
+		for _, p := range ts.Partitions {
+			replicas, _ := health.Dedup(p.Replicas)
+			for _, id := range replicas {
+				if _, live := b.Replicas[id]; live {
+					b.Replicas[id]++
+				}
+			}
+		}
+	}
+	b.Max, b.Mean, b.Ratio = imbalance(b.Replicas)
+	b.Failed = b.Ratio > b.MaxRatio
+	return b
+}
+
+// topicLeaders reports how evenly the leadership of the partitions of a topic
+// is spread over the brokers hosting its replicas, as the ratio of the highest
+// number of leaders on a broker to the mean
+type topicLeaders struct {
+	Topic    string        `json:"topic"`
+	Leaders  map[int32]int `json:"leaders"`  // number of partitions led by broker
+	Max      int           `json:"max"`      // highest number of partitions led by a broker
+	Mean     float64       `json:"mean"`     // mean number of partitions led by broker
+	Ratio    float64       `json:"ratio"`    // Max / Mean
+	MaxRatio float64       `json:"maxRatio"` // ratio above which the check fails
+	Failed   bool          `json:"failed"`   // Ratio exceeds MaxRatio
+}
+
+// computeTopicLeaders counts the partitions of a topic led by each live
+// broker hosting some of its replicas. The excluded brokers are left out. It
+// returns nil if the topic is not in the snapshot
+func computeTopicLeaders(state *clusterState, topic string, excluded []int32, maxRatio float64) *topicLeaders {
+	ts, ok := state.Topics[topic]
+	if !ok || ts.Err != "" {
+		return nil
+	}
+	l := &topicLeaders{
+		Topic:    topic,
+		Leaders:  make(map[int32]int),
+		MaxRatio: maxRatio,
+	}
+	for _, p := range ts.Partitions {
+		for _, id := range p.Replicas {
+			if _, live := state.Brokers[id]; live && !containsBroker(excluded, id) {
+				l.Leaders[id] = 0
+			}
+		}
+	}
+	for _, p := range ts.Partitions {
+		if _, ok := l.Leaders[p.Leader]; ok {
+			l.Leaders[p.Leader]++
+		}
+	}
+	l.Max, l.Mean, l.Ratio = imbalance(l.Leaders)
+	l.Failed = l.Ratio > l.MaxRatio
+	return l
+}
+
+// leaderSpread reports how evenly the preferred leaders of the partitions
+// of a topic, their first assigned replica, are spread over the brokers
+// hosting its replicas. It is the leadership intended by the assignment,
+// whatever the current leaders. An even spread gives each broker at most
+// Ideal partitions, so the ratio of the highest count to Ideal is 1
+type leaderSpread struct {
+	Topic    string        `json:"topic"`
+	Leaders  map[int32]int `json:"leaders"`  // number of partitions preferring each broker as leader
+	Max      int           `json:"max"`      // highest number of partitions preferring a broker
+	Ideal    int           `json:"ideal"`    // number of partitions by broker of an even spread, rounded up
+	Ratio    float64       `json:"ratio"`    // Max / Ideal
+	MaxRatio float64       `json:"maxRatio"` // ratio above which the check fails
+	Failed   bool          `json:"failed"`   // Ratio exceeds MaxRatio
+}
+
+// computePreferredLeaders counts the partitions of a topic preferring each
+// broker assigned some of its replicas as leader, live or not. The excluded
+// brokers are left out, as if they were not part of the assignment. It returns
+// nil if the topic is not in the snapshot or has no replica
+func computePreferredLeaders(state *clusterState, topic string, excluded []int32, maxRatio float64) *leaderSpread {
+	ts, ok := state.Topics[topic]
+	if !ok || ts.Err != "" {
+		return nil
+	}
+	l := &leaderSpread{
+		Topic:    topic,
+		Leaders:  make(map[int32]int),
+		MaxRatio: maxRatio,
+	}
+	total := 0
+	for _, p := range ts.Partitions {
+		p, _ = withoutBrokers(p, excluded)
+		if len(p.Replicas) == 0 {
+			continue
+		}
+		for _, id := range p.Replicas {
+			if _, ok := l.Leaders[id]; !ok {
+				l.Leaders[id] = 0
+			}
+		}
+		l.Leaders[p.Replicas[0]]++
+		total++
+	}
+	if total == 0 {
+		return nil
+	}
+	l.Max, _, _ = imbalance(l.Leaders)
+	l.Ideal = (total + len(l.Leaders) - 1) / len(l.Leaders)
+	l.Ratio = float64(l.Max) / float64(l.Ideal)
+	l.Failed = l.Ratio > l.MaxRatio
+	return l
+}
+
+// imbalance returns the highest of the counts, their mean, and the ratio of
+// the highest to the mean
+func imbalance(counts map[int32]int) (int, float64, float64) {
+	max, total := 0, 0
+	for _, n := range counts {
+		total += n
+		if n > max {
+			max = n
+		}
+	}
+	if total == 0 {
+		return 0, 0, 0
+	}
+	mean := float64(total) / float64(len(counts))
+	return max, mean, float64(max) / mean
+}
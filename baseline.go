@@ -0,0 +1,119 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+)
+
+// policies deciding which differences with the baseline fail the check
+const (
+	policyNew     = "new"     // only the failures not in the baseline
+	policyChanged = "changed" // also the failures whose replicas changed since the baseline
+)
+
+// baselineDiff is the difference between the failures of a baseline report
+// and the ones of the current scan. Failures are matched by topic, partition
+// and category
+type baselineDiff struct {
+	Policy  string    `json:"policy"`  // which differences fail the check
+	Added   []failure `json:"added"`   // failures not in the baseline
+	Removed []failure `json:"removed"` // failures of the baseline that are fixed
+	Changed []failure `json:"changed"` // failures in the baseline whose replicas changed
+}
+
+// validBaselinePolicy returns true if policy is a supported baselinePolicy
+func validBaselinePolicy(policy string) bool {
+	switch policy {
+	case policyNew, policyChanged:
+		return true
+	}
+	return false
+}
+
+// loadBaseline reads a report written as JSON by a previous run, gzip
+// compressed if path ends in .gz
+func loadBaseline(path string) (*report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped(path) {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var rep report
+	if err := json.NewDecoder(r).Decode(&rep); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %s", path, err)
+	}
+	return &rep, nil
+}
+
+// failureKey identifies a failure across reports
+func failureKey(f failure) string {
+	return fmt.Sprintf("%s:%d:%s", f.Topic, f.Partition, f.Category)
+}
+
+// diffFailures compares the failures of the current scan to the ones of the
+// baseline
+func diffFailures(baseline, current []failure, policy string) baselineDiff {
+	diff := baselineDiff{
+		Policy:  policy,
+		Added:   []failure{},
+		Removed: []failure{},
+		Changed: []failure{},
+	}
+	known := make(map[string]failure, len(baseline))
+	for _, f := range baseline {
+		known[failureKey(f)] = f
+	}
+	seen := make(map[string]bool, len(current))
+	for _, f := range current {
+		key := failureKey(f)
+		seen[key] = true
+		old, ok := known[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, f)
+		case !reflect.DeepEqual(old.Replicas, f.Replicas):
+			diff.Changed = append(diff.Changed, f)
+		}
+	}
+	for _, f := range baseline {
+		if !seen[failureKey(f)] {
+			diff.Removed = append(diff.Removed, f)
+		}
+	}
+	return diff
+}
+
+// regressions returns the failures that fail the check, according to the
+// policy
+func (d *baselineDiff) regressions() []failure {
+	if d.Policy == policyChanged {
+		return append(append([]failure(nil), d.Added...), d.Changed...)
+	}
+	return d.Added
+}
+
+// isRegression returns true if the failure fails the check
+func (d *baselineDiff) isRegression(f failure) bool {
+	key := failureKey(f)
+	for _, r := range d.regressions() {
+		if failureKey(r) == key {
+			return true
+		}
+	}
+	return false
+}
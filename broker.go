@@ -0,0 +1,57 @@
+package main
+
+import "fmt"
+
+// brokerStats summarizes the role of a broker in the partitions it hosts
+type brokerStats struct {
+	ID        int32    `json:"id"`
+	Live      bool     `json:"live"`           // the broker is part of the cluster
+	Rack      string   `json:"rack,omitempty"` // rack label of the broker
+	Replicas  int      `json:"replicas"`       // number of partitions with a replica on the broker
+	InSync    int      `json:"inSync"`         // number of those replicas in the ISR
+	Leader    int      `json:"leader"`         // number of partitions led by the broker
+	OutOfSync []string `json:"outOfSync"`      // topic:partition of the replicas not in the ISR
+}
+
+// computeBrokerStats computes the stats of the broker id over the partitions
+// of the given topics, using the cluster snapshot
+func computeBrokerStats(state *clusterState, topics []string, id int32) brokerStats {
+	b, live := state.Brokers[id]
+	stats := brokerStats{
+		ID:        id,
+		Live:      live,
+		Rack:      b.Rack,
+		OutOfSync: []string{},
+	}
+	for _, topic := range topics {
+		ts, ok := state.Topics[topic]
+		if !ok {
+			continue
+		}
+		for _, p := range ts.Partitions {
+			if !containsBroker(p.Replicas, id) {
+				continue
+			}
+			stats.Replicas++
+			if p.Leader == id {
+				stats.Leader++
+			}
+			if containsBroker(p.ISR, id) {
+				stats.InSync++
+			} else {
+				stats.OutOfSync = append(stats.OutOfSync, fmt.Sprintf("%s:%d", topic, p.ID))
+			}
+		}
+	}
+	return stats
+}
+
+// containsBroker returns true if id is in the list of brokers
+func containsBroker(brokers []int32, id int32) bool {
+	for _, b := range brokers {
+		if b == id {
+			return true
+		}
+	}
+	return false
+}
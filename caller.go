@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// callerHook adds the source location of the code logging to the entries, as
+// the caller field. The frames of logrus and of our logging helpers are
+// skipped, so the caller is the scan code and not the logger internals
+type callerHook struct{}
+
+func (callerHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (callerHook) Fire(e *logrus.Entry) error {
+	setField(e, "caller", logCaller())
+	return nil
+}
+
+// setField sets a field of the entry from a hook. The data is shared with the
+// entry the log was written from, which may be in use by another goroutine,
+// so it is replaced by a copy
+func setField(e *logrus.Entry, key string, value interface{}) {
+	data := make(logrus.Fields, len(e.Data)+1)
+	for k, v := range e.Data {
+		data[k] = v
+	}
+	data[key] = value
+	e.Data = data
+}
+
+// logCaller returns the file:line of the first caller outside of the logging
+// code, or (NOFUNC) if there is none
+func logCaller() string {
+	var pcs [32]uintptr
+	// skip runtime.Callers, logCaller and Fire
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !loggingFrame(frame.Function) {
+			return fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+		}
+		if !more {
+			return "(NOFUNC)"
+		}
+	}
+}
+
+// loggingFrame returns true if the function is part of the logging code. The
+// functions of the main package are named after its import path instead of
+// main in the test binary
+func loggingFrame(function string) bool {
+	if strings.Contains(function, "github.com/sirupsen/logrus.") {
+		return true
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(function, "main."), "github.com/prune998/kafka-health.")
+	return name == "logf" ||
+		name == "(*warnings).add" ||
+		strings.HasPrefix(name, "(*saramaLogger).")
+}
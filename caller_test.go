@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCallerHook(t *testing.T) {
+	rec := &entryRecorder{}
+	log := newTestLogger(callerHook{}, rec)
+	var warns warnings
+
+	log.WithField("key", "value").Info("direct")
+	logf(log.WithField("key", "value"), logrus.InfoLevel, "through %s", "logf")
+	warns.add(log.WithField("key", "value"), "through %s", "warnings")
+
+	for msg, e := range rec.messages(logrus.InfoLevel) {
+		if caller := e.Data["caller"]; !strings.HasPrefix(caller.(string), "caller_test.go:") {
+			t.Errorf("caller of %q = %v, want caller_test.go", msg, caller)
+		}
+	}
+	if caller := rec.messages(logrus.WarnLevel)["through warnings"].Data["caller"]; !strings.HasPrefix(caller.(string), "caller_test.go:") {
+		t.Errorf("caller of the warning = %v, want caller_test.go", caller)
+	}
+}
+
+func TestCallerHookScan(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	meta := newTestMetadata(broker)
+	meta.AddTopicPartition("events", 0, 1, []int32{1}, []int32{1}, sarama.ErrNoError)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(meta),
+	})
+	s, rec := newTestScanner(t, broker, "events")
+	defer s.client.Close()
+
+	// the replica level of 2 is above the single broker
+	defer func(level int) { *replicaLevel = level }(*replicaLevel)
+	*replicaLevel = 2
+	if _, err := s.scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	warns := rec.messages(logrus.WarnLevel)
+	if len(warns) == 0 {
+		t.Fatal("no warning logged")
+	}
+	for msg, e := range warns {
+		if caller := e.Data["caller"]; !strings.HasPrefix(caller.(string), "scan.go:") {
+			t.Errorf("caller of %q = %v, want scan.go", msg, caller)
+		}
+	}
+}
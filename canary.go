@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// canaryResult is the round trip of a message through the -canaryTopic, which
+// proves the data path works where the metadata can only tell the replicas
+// are assigned
+type canaryResult struct {
+	Topic     string  `json:"topic"`
+	Partition int32   `json:"partition"`       // partition the message was produced to, -1 if it wasn't
+	Offset    int64   `json:"offset"`          // offset of the message, -1 if it wasn't produced
+	Latency   float64 `json:"latencySeconds"`  // time from the produce request to the message consumed back
+	Deadline  float64 `json:"deadlineSeconds"` // round trip above which the check fails, set by -canaryTimeout
+	Err       string  `json:"err,omitempty"`   // why the message didn't make the round trip in time
+	Failed    bool    `json:"failed"`
+}
+
+// canaryMessage is the value of the canary messages, unique to each scan
+type canaryMessage struct {
+	Name   string    `json:"name"`
+	ScanID string    `json:"scanID"`
+	Time   time.Time `json:"time"`
+}
+
+// runCanary produces a timestamped message to topic and consumes it back from
+// the offset it was written at. The message is produced with acks=all, so it
+// went through all the in-sync replicas of its partition. The check fails when
+// the round trip takes longer than deadline, or ctx is done first
+func runCanary(ctx context.Context, client sarama.Client, topic, scanID string, deadline time.Duration) *canaryResult {
+	res := &canaryResult{Topic: topic, Partition: -1, Offset: -1, Deadline: deadline.Seconds()}
+	start := time.Now()
+	err := canaryRoundTrip(ctx, client, res, scanID, start.Add(deadline))
+	took := time.Since(start)
+	res.Latency = took.Seconds()
+	if err == nil && took > deadline {
+		err = fmt.Errorf("round trip took %s, more than %s", took, deadline)
+	}
+	if err != nil {
+		res.Err = err.Error()
+		res.Failed = true
+	}
+	return res
+}
+
+// canaryRoundTrip produces the canary message and waits for it to be
+// consumed back, until the deadline. The producer and the consumer share the
+// connections of client
+func canaryRoundTrip(ctx context.Context, client sarama.Client, res *canaryResult, scanID string, deadline time.Time) error {
+	now := time.Now()
+	value, err := json.Marshal(canaryMessage{Name: *name, ScanID: scanID, Time: now})
+	if err != nil {
+		return err
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("error starting the producer: %s", err)
+	}
+	defer producer.Close()
+	res.Partition, res.Offset, err = producer.SendMessage(&sarama.ProducerMessage{
+		Topic:     res.Topic,
+		Value:     sarama.ByteEncoder(value),
+		Timestamp: now,
+	})
+	if err != nil {
+		res.Partition, res.Offset = -1, -1
+		return fmt.Errorf("error producing: %s", err)
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("error starting the consumer: %s", err)
+	}
+	defer consumer.Close()
+	pc, err := consumer.ConsumePartition(res.Topic, res.Partition, res.Offset)
+	if err != nil {
+		return fmt.Errorf("error consuming partition %d: %s", res.Partition, err)
+	}
+	defer pc.Close()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Offset != res.Offset {
+				continue
+			}
+			if !bytes.Equal(msg.Value, value) {
+				return fmt.Errorf("message consumed at offset %d is not the one produced", msg.Offset)
+			}
+			return nil
+		case err := <-pc.Errors():
+			return fmt.Errorf("error consuming partition %d: %s", res.Partition, err.Err)
+		case <-timer.C:
+			return fmt.Errorf("message produced to partition %d at offset %d not consumed back in time", res.Partition, res.Offset)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// what to do with the canary topic when the process stops, set by
+// -canaryCleanup
+const (
+	canaryCleanupNone     = ""         // the topic is left as is
+	canaryCleanupDelete   = "delete"   // the topic is deleted
+	canaryCleanupTruncate = "truncate" // the messages of the topic are deleted
+)
+
+// setupCanary creates the -canaryTopic if the cluster doesn't have it, with
+// -canaryPartitions, -canaryReplicationFactor and -canaryRetention. The list
+// of topics is checked first, as asking for the metadata of a missing topic
+// creates it with the defaults of the brokers when they auto create topics
+func setupCanary(log *logrus.Logger, client sarama.Client, brokers []string, config *sarama.Config) error {
+	if err := client.RefreshMetadata(); err != nil {
+		return err
+	}
+	existing, err := client.Topics()
+	if err != nil {
+		return err
+	}
+	if containsTopic(existing, *canaryTopic) {
+		return nil
+	}
+
+	// one partition by broker by default, so the canary goes through all of
+	// them over time
+	partitions := *canaryParts
+	if partitions == 0 {
+		partitions = len(client.Brokers())
+	}
+	rf := *canaryRF
+	if rf == 0 {
+		rf = *replicaLevel
+	}
+	detail := &sarama.TopicDetail{
+		NumPartitions:     int32(partitions),
+		ReplicationFactor: int16(rf),
+		ConfigEntries:     map[string]*string{},
+	}
+	if *canaryRetention > 0 {
+		retention := strconv.FormatInt(int64(*canaryRetention/time.Millisecond), 10)
+		detail.ConfigEntries["retention.ms"] = &retention
+	}
+
+	admin, err := sarama.NewClusterAdmin(brokers, config)
+	if err != nil {
+		return fmt.Errorf("error starting sarama cluster admin: %s", err)
+	}
+	defer admin.Close()
+	// another instance may have created it in the meantime
+	err = admin.CreateTopic(*canaryTopic, detail, false)
+	if err == sarama.ErrTopicAlreadyExists {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		"topic":             *canaryTopic,
+		"partitions":        partitions,
+		"replicationFactor": rf,
+		"retention":         canaryRetention.String(),
+	}).Infof("created the canary topic %s", *canaryTopic)
+	return nil
+}
+
+// cleanupCanary deletes the -canaryTopic, or its messages, as set by
+// -canaryCleanup, when the process stops. The errors are logged, they don't
+// fail the check
+func cleanupCanary(log *logrus.Logger, client sarama.Client, brokers []string, config *sarama.Config) {
+	if *canaryTopic == "" || *canaryCleanup == canaryCleanupNone {
+		return
+	}
+	entry := log.WithFields(logrus.Fields{
+		"topic":   *canaryTopic,
+		"cleanup": *canaryCleanup,
+	})
+	admin, err := sarama.NewClusterAdmin(brokers, config)
+	if err != nil {
+		entry.WithField("err", err).Warn("Error Cleaning Up Canary Topic")
+		return
+	}
+	defer admin.Close()
+	if *canaryCleanup == canaryCleanupDelete {
+		err = admin.DeleteTopic(*canaryTopic)
+	} else {
+		err = truncateTopic(client, admin, *canaryTopic)
+	}
+	if err != nil {
+		entry.WithField("err", err).Warn("Error Cleaning Up Canary Topic")
+		return
+	}
+	entry.Infof("cleaned up the canary topic %s", *canaryTopic)
+}
+
+// truncateTopic deletes the messages of all the partitions of the topic, up
+// to their end offset
+func truncateTopic(client sarama.Client, admin sarama.ClusterAdmin, topic string) error {
+	ids, err := client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	offsets := make(map[int32]int64, len(ids))
+	for _, id := range ids {
+		offset, err := client.GetOffset(topic, id, sarama.OffsetNewest)
+		if err != nil {
+			return fmt.Errorf("error getting the end offset of partition %d: %s", id, err)
+		}
+		offsets[id] = offset
+	}
+	return admin.DeleteRecords(topic, offsets)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkpoint periodically records the last topic completely scanned into a
+// file, so an interrupted scan can be resumed from there with -resumeFrom. A
+// nil checkpoint does nothing
+type checkpoint struct {
+	path     string
+	interval time.Duration
+	log      *logrus.Logger
+	topic    string    // last topic completely scanned
+	written  time.Time // when the file was last written
+}
+
+func newCheckpoint(path string, interval time.Duration, log *logrus.Logger) *checkpoint {
+	if path == "" {
+		return nil
+	}
+	return &checkpoint{
+		path:     path,
+		interval: interval,
+		log:      log,
+		written:  time.Now(),
+	}
+}
+
+// done records that topic was completely scanned, and writes the file if the
+// interval has elapsed since it was last written
+func (c *checkpoint) done(topic string) {
+	if c == nil {
+		return
+	}
+	c.topic = topic
+	if time.Since(c.written) >= c.interval {
+		c.flush()
+	}
+}
+
+// flush writes the last topic completely scanned to the file. The file is
+// replaced atomically so it is never read partially written
+func (c *checkpoint) flush() {
+	if c == nil || c.topic == "" {
+		return
+	}
+	c.written = time.Now()
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err == nil {
+		_, err = tmp.WriteString(c.topic + "\n")
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), c.path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"err":  err,
+			"file": c.path,
+		}).Warn("Error Writing Checkpoint")
+	}
+}
+
+// remove deletes the file once the scan is complete, as there is nothing left
+// to resume
+func (c *checkpoint) remove() {
+	if c == nil {
+		return
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		c.log.WithFields(logrus.Fields{
+			"err":  err,
+			"file": c.path,
+		}).Warn("Error Removing Checkpoint")
+	}
+}
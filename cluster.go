@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Shopify/sarama"
+	"github.com/prune998/kafka-health/health"
+)
+
+// clusterState is a snapshot of the cluster metadata. It is gathered once at
+// the start of a scan, with a single metadata request, and shared by all the
+// checks so they all see the same consistent view of the cluster
+type clusterState struct {
+	ClusterID  string                 `json:"clusterID"`  // ID of the cluster, empty if unknown
+	Controller int32                  `json:"controller"` // ID of the controller broker, -1 if unknown
+	Brokers    map[int32]brokerState  `json:"brokers"`    // brokers currently part of the cluster, by ID
+	Topics     map[string]*topicState `json:"topics"`     // topics, by name
+}
+
+// brokerState describes a live broker of the cluster
+type brokerState struct {
+	ID   int32  `json:"id"`
+	Addr string `json:"addr"`           // advertised host:port
+	Rack string `json:"rack,omitempty"` // rack label, empty if the broker has none
+}
+
+// topicState describes a topic and its partitions
+type topicState struct {
+	Name       string           `json:"name"`
+	Internal   bool             `json:"internal"`      // true for Kafka's internal topics (__consumer_offsets...)
+	Err        string           `json:"err,omitempty"` // error reported by Kafka for the topic, if any
+	Partitions []partitionState `json:"partitions"`    // partitions, sorted by ID
+}
+
+// partitionState describes a partition, as reported by the cluster metadata
+type partitionState struct {
+	ID       int32   `json:"id"`
+	Leader   int32   `json:"leader"`            // ID of the leader broker, -1 if there is none
+	Replicas []int32 `json:"replicas"`          // assigned replicas, the first one being the preferred leader
+	ISR      []int32 `json:"isr"`               // in-sync replicas
+	Offline  []int32 `json:"offline,omitempty"` // replicas hosted on an offline log directory or broker
+	Err      string  `json:"err,omitempty"`     // error reported by Kafka for the partition, if any
+}
+
+// fetchClusterState queries the metadata of the given topics, or all the
+// topics if none are given, and builds a snapshot of the cluster. The request
+// is sent to the controller and never creates missing topics, from Kafka
+// 0.11. On error, the connection to the controller is closed and the
+// controller is looked up again, so the next call reconnects to the current
+// one
+func fetchClusterState(client sarama.Client, topics []string) (*clusterState, error) {
+	resp, err := health.FetchMetadata(client, topics)
+	if err != nil {
+		return nil, err
+	}
+	return newClusterState(resp), nil
+}
+
+// newClusterState builds a snapshot from a metadata response
+func newClusterState(resp *sarama.MetadataResponse) *clusterState {
+	state := &clusterState{
+		Controller: resp.ControllerID,
+		Brokers:    make(map[int32]brokerState, len(resp.Brokers)),
+		Topics:     make(map[string]*topicState, len(resp.Topics)),
+	}
+	if resp.ClusterID != nil {
+		state.ClusterID = *resp.ClusterID
+	}
+	for _, b := range resp.Brokers {
+		state.Brokers[b.ID()] = brokerState{
+			ID:   b.ID(),
+			Addr: b.Addr(),
+			Rack: brokerRack(b),
+		}
+	}
+	for _, t := range resp.Topics {
+		ts := &topicState{
+			Name:       t.Name,
+			Internal:   t.IsInternal,
+			Partitions: make([]partitionState, 0, len(t.Partitions)),
+		}
+		if t.Err != sarama.ErrNoError {
+			ts.Err = t.Err.Error()
+		}
+		for _, p := range t.Partitions {
+			ps := partitionState{
+				ID:       p.ID,
+				Leader:   p.Leader,
+				Replicas: p.Replicas,
+				ISR:      p.Isr,
+				Offline:  p.OfflineReplicas,
+			}
+			if p.Err != sarama.ErrNoError {
+				ps.Err = p.Err.Error()
+			}
+			ts.Partitions = append(ts.Partitions, ps)
+		}
+		sort.Slice(ts.Partitions, func(i, j int) bool { return ts.Partitions[i].ID < ts.Partitions[j].ID })
+		state.Topics[t.Name] = ts
+	}
+	return state
+}
+
+// dumpClusterState writes the snapshot as indented JSON
+func dumpClusterState(w io.Writer, state *clusterState) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
+
+// TopicNames returns the sorted list of the topics in the snapshot
+func (s *clusterState) TopicNames() []string {
+	names := make([]string, 0, len(s.Topics))
+	for name := range s.Topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// replicationFactor returns the replication factor the topic was created
+// with, or last reassigned to. Kafka doesn't record it, so it is the number of
+// replicas assigned to most of its partitions, the lowest one on a tie
+func (t *topicState) replicationFactor() int {
+	counts := make(map[int]int)
+	for _, p := range t.Partitions {
+		counts[len(p.Replicas)]++
+	}
+	rf, max := 0, 0
+	for n, c := range counts {
+		if c > max || c == max && n < rf {
+			rf, max = n, c
+		}
+	}
+	return rf
+}
+
+// hasPartition returns true if the topic has the partition id
+func (t *topicState) hasPartition(id int32) bool {
+	for _, p := range t.Partitions {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// beingDeleted returns true if the topic looks like it is being deleted: none
+// of its partitions has replicas anymore. Kafka doesn't flag the topics marked
+// for deletion in the metadata, this is the state they go through
+func (t *topicState) beingDeleted() bool {
+	if t.Err != "" {
+		return false
+	}
+	for _, p := range t.Partitions {
+		if len(p.Replicas) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// BrokerIDs returns the sorted IDs of the live brokers in the snapshot
+func (s *clusterState) BrokerIDs() []int32 {
+	ids := make([]int32, 0, len(s.Brokers))
+	for id := range s.Brokers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// brokerRacks maps the IDs of the live brokers to their rack label, empty for
+// the brokers without one
+type brokerRacks map[int32]string
+
+// Racks returns the rack of each live broker of the snapshot
+func (s *clusterState) Racks() brokerRacks {
+	racks := make(brokerRacks, len(s.Brokers))
+	for id, b := range s.Brokers {
+		racks[id] = b.Rack
+	}
+	return racks
+}
+
+// missing returns the sorted IDs of the brokers without a rack, when some of
+// the brokers have one. A cluster without any rack label is not rack aware,
+// so nothing is missing
+func (r brokerRacks) missing() []int32 {
+	var ids []int32
+	for id, rack := range r {
+		if rack == "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == len(r) {
+		return nil
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// printRacks writes an aligned table of the live brokers, with their rack and
+// address, sorted by ID
+func printRacks(w io.Writer, state *clusterState) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BROKER\tRACK\tADDRESS")
+	for _, id := range state.BrokerIDs() {
+		b := state.Brokers[id]
+		rack := b.Rack
+		if rack == "" {
+			rack = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", id, rack, b.Addr)
+	}
+	return tw.Flush()
+}
+
+// brokerRack returns the rack of a broker. The vendored sarama decodes it from
+// the metadata but does not expose it, so we have to read the private field
+func brokerRack(b *sarama.Broker) string {
+	rack := reflect.ValueOf(b).Elem().FieldByName("rack")
+	if !rack.IsValid() || rack.IsNil() {
+		return ""
+	}
+	return rack.Elem().String()
+}
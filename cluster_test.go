@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// newTestMetadata returns a metadata response listing broker as the single
+// broker and the controller of the cluster, the topics are to be added
+func newTestMetadata(broker *sarama.MockBroker) *sarama.MetadataResponse {
+	meta := &sarama.MetadataResponse{Version: 1, ControllerID: broker.BrokerID()}
+	meta.AddBroker(broker.Addr(), broker.BrokerID())
+	return meta
+}
+
+// newTestClient returns a client connected to broker. It speaks Kafka 0.10,
+// so all its metadata requests, and the ones of the scans, are v1 and can be
+// answered by the fixed response of newTestMetadata
+func newTestClient(t *testing.T, broker *sarama.MockBroker) sarama.Client {
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_10_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatalf("error connecting to the mock broker: %s", err)
+	}
+	return client
+}
+
+func TestFetchClusterState(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	meta := newTestMetadata(broker)
+	// added out of order, the snapshot sorts the partitions
+	meta.AddTopicPartition("events", 1, -1, []int32{2, 1}, nil, sarama.ErrNoError)
+	meta.AddTopicPartition("events", 0, 1, []int32{1, 2}, []int32{1, 2}, sarama.ErrNoError)
+	meta.AddTopicPartition("__consumer_offsets", 0, 1, []int32{1}, []int32{1}, sarama.ErrNoError)
+	meta.Topics[1].IsInternal = true
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(meta),
+	})
+	client := newTestClient(t, broker)
+	defer client.Close()
+	// the client fails to start on a missing topic, it is added after
+	meta.AddTopic("missing", sarama.ErrUnknownTopicOrPartition)
+
+	state, err := fetchClusterState(client, []string{"events", "missing", "__consumer_offsets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &clusterState{
+		Controller: 1,
+		Brokers: map[int32]brokerState{
+			1: {ID: 1, Addr: broker.Addr()},
+		},
+		Topics: map[string]*topicState{
+			"events": {
+				Name: "events",
+				Partitions: []partitionState{
+					{ID: 0, Leader: 1, Replicas: []int32{1, 2}, ISR: []int32{1, 2}},
+					{ID: 1, Leader: -1, Replicas: []int32{2, 1}},
+				},
+			},
+			"missing": {
+				Name:       "missing",
+				Err:        sarama.ErrUnknownTopicOrPartition.Error(),
+				Partitions: []partitionState{},
+			},
+			"__consumer_offsets": {
+				Name:     "__consumer_offsets",
+				Internal: true,
+				Partitions: []partitionState{
+					{ID: 0, Leader: 1, Replicas: []int32{1}, ISR: []int32{1}},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(state, want) {
+		t.Errorf("snapshot = %s, want %s", dumpTest(state), dumpTest(want))
+	}
+	if names := state.TopicNames(); !reflect.DeepEqual(names, []string{"__consumer_offsets", "events", "missing"}) {
+		t.Errorf("TopicNames() = %v, want them sorted", names)
+	}
+}
+
+// dumpTest returns the snapshot as JSON, to compare them in the errors
+func dumpTest(state *clusterState) string {
+	var buf bytes.Buffer
+	dumpClusterState(&buf, state)
+	return buf.String()
+}
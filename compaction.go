@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// isCompacted returns true if the cleanup.policy of a topic includes compact
+func isCompacted(configs map[string]string) bool {
+	for _, policy := range strings.Split(configs["cleanup.policy"], ",") {
+		if strings.TrimSpace(policy) == "compact" {
+			return true
+		}
+	}
+	return false
+}
+
+// offsetSpan returns the number of offsets between the start and the end of
+// the log of a partition. On a compacted topic, it keeps growing when the
+// compaction doesn't keep up. It costs two requests to the leader
+func (s *scanner) offsetSpan(ctx context.Context, topic string, partition int32) (int64, error) {
+	if err := s.limiter.wait(ctx); err != nil {
+		return 0, err
+	}
+	oldest, err := s.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.limiter.wait(ctx); err != nil {
+		return 0, err
+	}
+	newest, err := s.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+	return newest - oldest, nil
+}
+
+// offsetSpanContext is offsetSpan, given up when ctx is done. The requests in
+// flight are left to complete in the background, within the timeouts of the
+// connection
+func (s *scanner) offsetSpanContext(ctx context.Context, topic string, partition int32) (int64, error) {
+	done := make(chan spanResult, 1)
+	go func() {
+		span, err := s.offsetSpan(ctx, topic, partition)
+		done <- spanResult{span: span, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.span, r.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// spanResult is the span of a partition fetched ahead of the checks
+type spanResult struct {
+	span     int64
+	err      error
+	timedOut bool // not fetched, its topic ran out of -perTopicTimeout
+}
+
+// offsetSpans holds the spans of the partitions of the compacted topics
+type offsetSpans struct {
+	mu    sync.Mutex
+	spans map[string]spanResult    // by topic:partition
+	took  map[string]time.Duration // time spent fetching the spans, by topic
+}
+
+// get returns the span of a partition
+func (o *offsetSpans) get(topic string, partition int32) spanResult {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.spans[partitionName(topic, partition)]
+}
+
+// fetchOffsetSpans fetches the span of the partitions led by a broker of the
+// compacted topics about to be checked. Up to maxTopics topics are fetched at
+// once, each fetching up to maxPartitions partitions at once, so up to
+// maxTopics * maxPartitions * 2 requests are in flight. Each topic is fetched
+// under a context expiring after -perTopicTimeout, the partitions whose
+// requests it interrupts, or not sent yet, are marked as timed out. Once ctx
+// is done, the remaining partitions get its error
+func (s *scanner) fetchOffsetSpans(ctx context.Context, state *clusterState, topics []string, configs map[string]map[string]string, maxTopics, maxPartitions int) *offsetSpans {
+	o := &offsetSpans{
+		spans: make(map[string]spanResult),
+		took:  make(map[string]time.Duration),
+	}
+	topicSlots := make(chan struct{}, maxTopics)
+	var wg sync.WaitGroup
+	for _, topic := range topics {
+		ts, ok := state.Topics[topic]
+		if !ok || ts.Err != "" || !isCompacted(configs[topic]) || s.resumeFrom != "" && topic <= s.resumeFrom {
+			continue
+		}
+		var partitions []int32
+		for _, p := range ts.Partitions {
+			if p.Leader >= 0 && s.selected(topic, p) {
+				partitions = append(partitions, p.ID)
+			}
+		}
+
+		wg.Add(1)
+		topicSlots <- struct{}{}
+		go func(topic string, partitions []int32) {
+			defer func() {
+				<-topicSlots
+				wg.Done()
+			}()
+			start := time.Now()
+			tctx, cancel := ctx, context.CancelFunc(func() {})
+			if *topicTimeout > 0 {
+				tctx, cancel = context.WithTimeout(ctx, *topicTimeout)
+			}
+			defer cancel()
+			partitionSlots := make(chan struct{}, maxPartitions)
+			var pwg sync.WaitGroup
+			for _, id := range partitions {
+				pwg.Add(1)
+				partitionSlots <- struct{}{}
+				go func(id int32) {
+					defer func() {
+						<-partitionSlots
+						pwg.Done()
+					}()
+					r := spanResult{timedOut: true}
+					switch {
+					case ctx.Err() != nil:
+						r = spanResult{err: ctx.Err()}
+					case tctx.Err() == nil:
+						r = spanResult{}
+						r.span, r.err = s.offsetSpanContext(tctx, topic, id)
+						// interrupted by the timeout of the topic
+						if r.err != nil && r.err == tctx.Err() && ctx.Err() == nil {
+							r = spanResult{timedOut: true}
+						}
+					}
+					o.mu.Lock()
+					o.spans[partitionName(topic, id)] = r
+					o.mu.Unlock()
+				}(id)
+			}
+			pwg.Wait()
+			o.mu.Lock()
+			o.took[topic] = time.Since(start)
+			o.mu.Unlock()
+		}(topic, partitions)
+	}
+	wg.Wait()
+	return o
+}
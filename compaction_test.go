@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestFetchOffsetSpansTopicTimeout(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	meta := newTestMetadata(broker)
+	meta.AddTopicPartition("compacted", 0, 1, []int32{1}, []int32{1}, sarama.ErrNoError)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(meta),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("compacted", 0, sarama.OffsetOldest, 0).
+			SetOffset("compacted", 0, sarama.OffsetNewest, 10),
+	})
+	client := newTestClient(t, broker)
+	defer client.Close()
+
+	state := newClusterState(meta)
+	configs := map[string]map[string]string{"compacted": {"cleanup.policy": "compact"}}
+	s := &scanner{client: client}
+
+	defer func(timeout time.Duration) { *topicTimeout = timeout }(*topicTimeout)
+	*topicTimeout = time.Second
+	if span := s.fetchOffsetSpans(context.Background(), state, []string{"compacted"}, configs, 1, 1).get("compacted", 0); span.err != nil || span.timedOut || span.span != 10 {
+		t.Fatalf("span = %+v, want 10", span)
+	}
+
+	// the leader now answers after the timeout of the topic
+	latency := 500 * time.Millisecond
+	broker.SetLatency(latency)
+	*topicTimeout = 50 * time.Millisecond
+	start := time.Now()
+	span := s.fetchOffsetSpans(context.Background(), state, []string{"compacted"}, configs, 1, 1).get("compacted", 0)
+	if took := time.Since(start); took >= latency {
+		t.Errorf("fetching the spans took %s, not interrupted after %s", took, *topicTimeout)
+	}
+	if !span.timedOut {
+		t.Errorf("span = %+v, want timed out", span)
+	}
+	// let the request given up complete before closing the broker
+	time.Sleep(2 * latency)
+}
+
+func TestScanWarnsCompactionLag(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	meta := newTestMetadata(broker)
+	meta.AddTopicPartition("compacted", 0, 1, []int32{1}, []int32{1}, sarama.ErrNoError)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(meta),
+		"DescribeConfigsRequest": sarama.NewMockWrapper(&sarama.DescribeConfigsResponse{
+			Resources: []*sarama.ResourceResponse{{
+				Type:    sarama.TopicResource,
+				Name:    "compacted",
+				Configs: []*sarama.ConfigEntry{{Name: "cleanup.policy", Value: "compact"}},
+			}},
+		}),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetVersion(1).
+			SetOffset("compacted", 0, sarama.OffsetOldest, 0).
+			SetOffset("compacted", 0, sarama.OffsetNewest, 10),
+	})
+	s, _ := newTestScanner(t, broker, "compacted")
+	defer s.client.Close()
+	// the configs can only be described from Kafka 0.11, the metadata and
+	// the offsets are then requested with v5 and v1
+	s.config.Version = sarama.V1_0_0_0
+	meta.Version = 5
+
+	defer func(span int64, level int) { *maxCompactedSpan, *replicaLevel = span, level }(*maxCompactedSpan, *replicaLevel)
+	*replicaLevel = 1
+	warning := "partition compacted:0 spans 10 offsets, its compaction may be lagging"
+	for span, warned := range map[int64]bool{10: false, 5: true} {
+		*maxCompactedSpan = span
+		rep, err := s.scan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := containsTopic(rep.Warnings, warning); got != warned {
+			t.Errorf("maxCompactedSpan %d warned %v, want %v: %q", span, got, warned, rep.Warnings)
+		}
+		if len(rep.Failures) > 0 || !rep.Healthy() {
+			t.Errorf("maxCompactedSpan %d failed the check: %v", span, rep.Failures)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+)
+
+// fetchTopicConfigs returns the given configs of the topics, by topic and by
+// name, with a single request to the controller. The values include the
+// defaults of the brokers for the configs not set on a topic
+func fetchTopicConfigs(client sarama.Client, topics []string, names []string) (map[string]map[string]string, error) {
+	configs := make(map[string]map[string]string, len(topics))
+	if len(topics) == 0 {
+		return configs, nil
+	}
+	controller, err := client.Controller()
+	if err != nil {
+		return nil, err
+	}
+	req := &sarama.DescribeConfigsRequest{}
+	for _, topic := range topics {
+		req.Resources = append(req.Resources, &sarama.ConfigResource{
+			Type:        sarama.TopicResource,
+			Name:        topic,
+			ConfigNames: names,
+		})
+	}
+	resp, err := controller.DescribeConfigs(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range resp.Resources {
+		if res.ErrorMsg != "" {
+			return nil, fmt.Errorf("error describing the configs of topic %s: %s", res.Name, res.ErrorMsg)
+		}
+		configs[res.Name] = make(map[string]string, len(res.Configs))
+		for _, c := range res.Configs {
+			configs[res.Name][c.Name] = c.Value
+		}
+	}
+	return configs, nil
+}
+
+// intConfig returns the value of an integer config, 0 if it is missing or
+// invalid
+func intConfig(configs map[string]string, name string) int {
+	v, err := strconv.Atoi(configs[name])
+	if err != nil {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/Shopify/sarama"
+	"github.com/prune998/kafka-health/health"
+	"github.com/sirupsen/logrus"
+)
+
+// partitionView is a partition as seen by a broker
+type partitionView struct {
+	Replicas []int32 `json:"replicas"`
+	ISR      []int32 `json:"isr"`
+	Missing  bool    `json:"missing,omitempty"` // the broker doesn't know the partition
+}
+
+// fetchBrokerViews queries the metadata of the given topics, or all the topics
+// if none are given, from each live broker individually, or only from the
+// brokers in only if it is not nil. The brokers that can't be queried are
+// logged and left out, so are the ones left when ctx is done
+func (s *scanner) fetchBrokerViews(ctx context.Context, log *logrus.Entry, topics []string, only map[int32]bool) map[int32]*clusterState {
+	views := make(map[int32]*clusterState)
+	for _, b := range s.client.Brokers() {
+		if only != nil && !only[b.ID()] {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		// Open does nothing if the broker is already connected
+		b.Open(s.config)
+		if s.limiter.wait(ctx) != nil {
+			break
+		}
+		resp, err := b.GetMetadata(&sarama.MetadataRequest{
+			Version:                health.MetadataVersion(s.config.Version),
+			Topics:                 topics,
+			AllowAutoTopicCreation: false,
+		})
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"err":    err,
+				"broker": b.ID(),
+				"addr":   b.Addr(),
+			}).Warn("Error Fetching Metadata From Broker")
+			continue
+		}
+		views[b.ID()] = newClusterState(resp)
+	}
+	return views
+}
+
+// leaderIDs returns the IDs of the brokers leading some partitions
+func leaderIDs(state *clusterState) map[int32]bool {
+	ids := make(map[int32]bool)
+	for _, ts := range state.Topics {
+		for _, p := range ts.Partitions {
+			if p.Leader >= 0 {
+				ids[p.Leader] = true
+			}
+		}
+	}
+	return ids
+}
+
+// divergentViews returns the views of the partition by broker, including the
+// reference one, if at least one of the brokers disagrees with the reference
+// on its replicas or ISR. It returns nil if they all agree
+func divergentViews(ref partitionState, refID int32, topic string, views map[int32]*clusterState) map[int32]partitionView {
+	all := map[int32]partitionView{
+		refID: {Replicas: ref.Replicas, ISR: ref.ISR},
+	}
+	divergent := false
+	for id, state := range views {
+		if id == refID {
+			continue
+		}
+		view := partitionView{Missing: true}
+		if ts, ok := state.Topics[topic]; ok {
+			for _, p := range ts.Partitions {
+				if p.ID == ref.ID {
+					view = partitionView{Replicas: p.Replicas, ISR: p.ISR}
+				}
+			}
+		}
+		all[id] = view
+		if view.Missing || !reflect.DeepEqual(view.Replicas, ref.Replicas) || !sameBrokers(view.ISR, ref.ISR) {
+			divergent = true
+		}
+	}
+	if !divergent {
+		return nil
+	}
+	return all
+}
+
+// sameBrokers returns true if both lists have the same brokers, in any order
+func sameBrokers(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sa := append([]int32(nil), a...)
+	sb := append([]int32(nil), b...)
+	sort.Slice(sa, func(i, j int) bool { return sa[i] < sa[j] })
+	sort.Slice(sb, func(i, j int) bool { return sb[i] < sb[j] })
+	return reflect.DeepEqual(sa, sb)
+}
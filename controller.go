@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// controllerInfo reports how often the controller changed recently. Frequent
+// re-elections of the controller are a sign of an unstable cluster, like
+// brokers losing their ZooKeeper session
+type controllerInfo struct {
+	ID      int32   `json:"id"`            // ID of the current controller
+	Changes int     `json:"changes"`       // changes of controller within the window
+	Window  float64 `json:"windowSeconds"` // how far back the changes are counted
+	Max     int     `json:"max"`           // number of changes above which the check fails
+	Failed  bool    `json:"failed"`        // Changes exceeds Max
+}
+
+// trackController compares the controller of the snapshot to the one of the
+// previous scan of the process, and logs a change. The changes within window
+// are kept, to report the controller as flapping when there are more than
+// max of them. A max of 0 only logs and counts the changes
+func (s *scanner) trackController(log *logrus.Entry, rep *report, state *clusterState, max int, window time.Duration) {
+	if state.Controller < 0 {
+		return
+	}
+	now := time.Now()
+	if s.controllerSeen && state.Controller != s.controller {
+		log.WithFields(logrus.Fields{
+			"oldController": s.controller,
+			"newController": state.Controller,
+		}).Warnf("controller changed from broker %d to broker %d", s.controller, state.Controller)
+		s.flaps = append(s.flaps, now)
+		atomic.AddInt64(&s.flapCount, 1)
+	}
+	s.controller, s.controllerSeen = state.Controller, true
+
+	// forget the changes that left the window
+	recent := s.flaps[:0]
+	for _, t := range s.flaps {
+		if now.Sub(t) <= window {
+			recent = append(recent, t)
+		}
+	}
+	s.flaps = recent
+
+	if max > 0 {
+		rep.Controller = &controllerInfo{
+			ID:      state.Controller,
+			Changes: len(recent),
+			Window:  window.Seconds(),
+			Max:     max,
+			Failed:  len(recent) > max,
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// topicDrift lists the topics created or deleted since the reference set of
+// topics: the -topicsAllowList, or the topics of the first scan
+type topicDrift struct {
+	Added   []string `json:"added,omitempty"`   // topics not in the reference
+	Removed []string `json:"removed,omitempty"` // topics of the reference gone from the cluster
+}
+
+// checkTopicDrift compares the topics of the snapshot to the reference set,
+// taken from the first scan when there is no allow list. The internal and
+// excluded topics are ignored. It returns nil if the topics didn't change
+func (s *scanner) checkTopicDrift(log *logrus.Entry, state *clusterState) *topicDrift {
+	current := make(map[string]bool)
+	for name, ts := range state.Topics {
+		if !ts.Internal && !s.excludedTopics.excludes(name) {
+			current[name] = true
+		}
+	}
+	if s.knownTopics == nil {
+		s.knownTopics = current
+		log.WithFields(logrus.Fields{
+			"topics": len(current),
+		}).Info("reference set of topics taken from the first scan")
+		return nil
+	}
+
+	drift := &topicDrift{}
+	for name := range current {
+		if !s.knownTopics[name] {
+			drift.Added = append(drift.Added, name)
+		}
+	}
+	for name := range s.knownTopics {
+		ts, ok := state.Topics[name]
+		if ok && ts.Internal || s.excludedTopics.excludes(name) {
+			continue
+		}
+		if !current[name] {
+			drift.Removed = append(drift.Removed, name)
+		}
+	}
+	if len(drift.Added) == 0 && len(drift.Removed) == 0 {
+		return nil
+	}
+	sort.Strings(drift.Added)
+	sort.Strings(drift.Removed)
+	return drift
+}
@@ -0,0 +1,40 @@
+package main
+
+import "github.com/sirupsen/logrus"
+
+// escalate counts, across the scans of the process, the consecutive scans
+// each partition failed, and escalates the WARN failures of the partitions
+// failing for at least after scans to CRITICAL, which fails the check. An
+// event is logged when a partition reaches the threshold. The count of a
+// partition is reset as soon as a scan finds it healthy
+func (s *scanner) escalate(log *logrus.Entry, rep *report, after int) {
+	streaks := make(map[string]int)
+	for _, f := range rep.Failures {
+		name := partitionName(f.Topic, f.Partition)
+		if _, counted := streaks[name]; !counted {
+			streaks[name] = s.streaks[name] + 1
+		}
+	}
+	s.streaks = streaks
+
+	escalated := make(map[string]bool)
+	for i, f := range rep.Failures {
+		name := partitionName(f.Topic, f.Partition)
+		if streaks[name] < after || f.Severity != severityWarn {
+			continue
+		}
+		rep.Failures[i].Severity = severityCritical
+		rep.Failed = true
+		if !escalated[name] {
+			escalated[name] = true
+			rep.Escalated = append(rep.Escalated, name)
+			if streaks[name] == after {
+				log.WithFields(logrus.Fields{
+					"topic":     f.Topic,
+					"partition": f.Partition,
+					"scans":     streaks[name],
+				}).Errorf("partition %s failed %d consecutive scans, escalated to %s", name, streaks[name], severityCritical)
+			}
+		}
+	}
+}
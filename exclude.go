@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// parseBrokerIDs parses a comma separated list of broker IDs
+func parseBrokerIDs(s string) ([]int32, error) {
+	var ids []int32
+	for _, item := range splitList(s) {
+		id, err := strconv.ParseInt(item, 10, 32)
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid broker ID %q", item)
+		}
+		ids = append(ids, int32(id))
+	}
+	return ids, nil
+}
+
+// withoutBrokers returns the partition as if the excluded brokers were not
+// part of the cluster: they are removed from its replicas, ISR and offline
+// replicas. It also returns the number of replicas removed from the
+// assignment, by which the expected replica level is lowered
+func withoutBrokers(p partitionState, excluded []int32) (partitionState, int) {
+	if len(excluded) == 0 {
+		return p, 0
+	}
+	replicas := filterBrokers(p.Replicas, excluded)
+	removed := len(p.Replicas) - len(replicas)
+	p.Replicas = replicas
+	p.ISR = filterBrokers(p.ISR, excluded)
+	p.Offline = filterBrokers(p.Offline, excluded)
+	return p, removed
+}
+
+// filterBrokers returns the brokers that are not excluded, keeping their order
+func filterBrokers(brokers, excluded []int32) []int32 {
+	kept := make([]int32, 0, len(brokers))
+	for _, id := range brokers {
+		if !containsBroker(excluded, id) {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// topicExclusion holds the topics left out of the scans, by exact name or
+// by regular expression matching the whole name
+type topicExclusion struct {
+	patterns []string         // distinct patterns, in the order they were given
+	res      []*regexp.Regexp // compiled patterns, anchored on both ends
+}
+
+// newTopicExclusion merges the -excludeTopics pattern with the patterns of
+// the file, if any. The file has one topic name or pattern by line, the blank
+// lines and the lines starting with # are ignored. Duplicates are dropped
+func newTopicExclusion(pattern, path string) (*topicExclusion, error) {
+	patterns := []string{pattern}
+	if path != "" {
+		lines, err := readListFile(path)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, lines...)
+	}
+	e := &topicExclusion{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" || containsTopic(e.patterns, p) {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", p, err)
+		}
+		e.patterns = append(e.patterns, p)
+		e.res = append(e.res, re)
+	}
+	return e, nil
+}
+
+// excludes returns true if the topic is excluded, matching one of the
+// patterns exactly or as a regular expression
+func (e *topicExclusion) excludes(topic string) bool {
+	if e == nil {
+		return false
+	}
+	for i, re := range e.res {
+		if topic == e.patterns[i] || re.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the topics that are not excluded, keeping their order, and
+// the number of topics excluded
+func (e *topicExclusion) filter(topics []string) ([]string, int) {
+	kept := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if !e.excludes(topic) {
+			kept = append(kept, topic)
+		}
+	}
+	return kept, len(topics) - len(kept)
+}
+
+// readListFile reads a file with one item by line. The spaces around each
+// line are trimmed, the blank lines and the lines starting with # are ignored
+func readListFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			items = append(items, line)
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prune998/kafka-health/health"
+	"github.com/sirupsen/logrus"
+)
+
+// underReplicated returns true if the partition doesn't have the expected
+// number of replicas in state, as checked by the scan, or has replicas out of
+// sync with -checkOutOfSyncReplicas
+func (s *scanner) underReplicated(state *clusterState, topic string, p partitionState) bool {
+	p, removed := withoutBrokers(p, s.excluded)
+	level := expectedReplicas(topic, state.Topics[topic], s.tiers) - removed
+	replicas, _ := health.Dedup(countReplicas(state, p))
+	return level > 0 && len(replicas) != level || *checkOutOfSync && len(outOfSyncReplicas(p)) > 0
+}
+
+// recheckAfterGrace finds the partitions under-replicated or offline in
+// state, waits for the grace period, then fetches the metadata of their
+// topics again, and returns the partitions that recovered in the meantime, by
+// topic:partition. The brief ISR shrinks following a broker restart heal
+// within seconds. If the metadata can't be fetched again, or ctx is done
+// during the grace period, none recovered
+func (s *scanner) recheckAfterGrace(ctx context.Context, log *logrus.Entry, state *clusterState, grace time.Duration) map[string]bool {
+	flagged := make(map[string][]partitionState)
+	var topics []string
+	for _, topic := range state.TopicNames() {
+		ts := state.Topics[topic]
+		if ts.Err != "" || ts.beingDeleted() {
+			continue
+		}
+		for _, p := range ts.Partitions {
+			if s.underReplicated(state, topic, p) {
+				flagged[topic] = append(flagged[topic], p)
+			}
+		}
+		if len(flagged[topic]) > 0 {
+			topics = append(topics, topic)
+		}
+	}
+	if len(topics) == 0 {
+		return nil
+	}
+
+	log.WithFields(logrus.Fields{
+		"topics": topics,
+		"grace":  grace.String(),
+	}).Infof("%d topics have partitions not fully replicated, checking them again in %s", len(topics), grace)
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil
+	}
+	if s.limiter.wait(ctx) != nil {
+		return nil
+	}
+	fresh, err := fetchClusterState(s.client, topics)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Warn("Error Fetching Metadata after the grace period")
+		return nil
+	}
+
+	recovered := make(map[string]bool)
+	for _, topic := range topics {
+		ts, ok := fresh.Topics[topic]
+		if !ok || ts.Err != "" {
+			continue
+		}
+		for _, p := range ts.Partitions {
+			if containsPartitionState(flagged[topic], p.ID) && !s.underReplicated(fresh, topic, p) {
+				recovered[partitionName(topic, p.ID)] = true
+			}
+		}
+	}
+	return recovered
+}
+
+// containsPartitionState returns true if the partition id is in the list
+func containsPartitionState(partitions []partitionState, id int32) bool {
+	for _, p := range partitions {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
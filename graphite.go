@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeGraphite writes the metrics of the report in the Graphite plaintext
+// protocol, one "path value timestamp" line per metric. The paths start with
+// prefix and the cluster ID, ex: kafka.health.<cluster>.under_replicated
+func writeGraphite(w io.Writer, prefix string, rep *report) error {
+	counts := make(map[string]int)
+	for _, f := range rep.allFailures() {
+		counts[f.Category]++
+	}
+	path := graphitePath(prefix, rep.Cluster)
+	ts := rep.Time.Unix()
+	metrics := []struct {
+		name  string
+		value interface{}
+	}{
+		{"under_replicated", counts[categoryUnderReplicated]},
+		{"offline", counts[categoryOffline]},
+		{"unhealthy_partitions", rep.Unhealthy},
+		{"checked_partitions", rep.Checked},
+		{"scan_duration_seconds", rep.Duration},
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "%s.%s %v %d\n", path, m.name, m.value, ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// graphitePath returns the prefix of the metrics of a cluster. The dots and
+// spaces of the cluster ID are replaced, as they would split the path
+func graphitePath(prefix, cluster string) string {
+	if cluster == "" {
+		cluster = "unknown"
+	}
+	cluster = strings.NewReplacer(".", "_", " ", "_").Replace(cluster)
+	if prefix == "" {
+		return cluster
+	}
+	return strings.TrimSuffix(prefix, ".") + "." + cluster
+}
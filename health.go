@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// partitionHealth records when each partition was last seen healthy, by
+// topic:partition. It is kept in a file across runs, to tell a momentary blip
+// from a partition that has been unhealthy for a while. A partition first seen
+// unhealthy is recorded with the time it was first seen
+type partitionHealth map[string]time.Time
+
+// loadPartitionHealth reads the file written by the previous runs. A missing
+// file means there was no previous run
+func loadPartitionHealth(path string) (partitionHealth, error) {
+	h := make(partitionHealth)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(b, &h); err != nil {
+		return make(partitionHealth), err
+	}
+	return h, nil
+}
+
+// update records the partitions checked at now, and returns the unhealthy
+// ones that were not seen healthy for longer than window
+func (h partitionHealth) update(now time.Time, checked []string, unhealthy map[string]bool, window time.Duration) map[string]bool {
+	persistent := make(map[string]bool)
+	for _, p := range checked {
+		last, known := h[p]
+		switch {
+		case !unhealthy[p] || !known:
+			h[p] = now
+		case now.Sub(last) > window:
+			persistent[p] = true
+		}
+	}
+	return persistent
+}
+
+// save writes the file. It is replaced atomically so it is never read
+// partially written
+func (h partitionHealth) save(path string) error {
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,210 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// CountMode tells which replicas of a partition are counted against the
+// ReplicaLevel
+type CountMode string
+
+// replica count modes
+const (
+	CountAssigned CountMode = "assigned" // every replica assigned to the partition, in sync or not
+	CountISR      CountMode = "isr"      // only the in-sync replicas
+	CountLive     CountMode = "live"     // the assigned replicas hosted on a live broker
+)
+
+// ReplicationOptions sets what CheckReplication checks. The zero value checks
+// that every partition of every topic has a leader
+type ReplicationOptions struct {
+	Topics          []string  // topics to check, all the topics of the cluster if empty
+	ReplicaLevel    int       // replicas required by partition, 0 to only require a leader
+	CountMode       CountMode // replicas counted against ReplicaLevel, CountAssigned if empty
+	ExcludedBrokers []int32   // brokers ignored, as if they were not part of the cluster
+	MinBrokers      int       // live brokers required, 0 to disable
+}
+
+// CheckReplication checks the replication of the partitions, from a single
+// snapshot of the metadata of the cluster. An error is returned when the
+// cluster can't be checked, or when ctx is done before the end of the check:
+// ctx is checked between the topics, a request already sent to a broker is
+// only bounded by the timeouts of the connection
+func (c *Cluster) CheckReplication(ctx context.Context, opts ReplicationOptions) (*Report, error) {
+	if !opts.CountMode.Valid() {
+		return nil, fmt.Errorf("unknown count mode %q", opts.CountMode)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := FetchMetadata(c.client, opts.Topics)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching metadata: %s", err)
+	}
+
+	rep := &Report{
+		Time:       start,
+		Controller: resp.ControllerID,
+	}
+	if resp.ClusterID != nil {
+		rep.Cluster = *resp.ClusterID
+	}
+	excluded := make(map[int32]bool, len(opts.ExcludedBrokers))
+	for _, id := range opts.ExcludedBrokers {
+		excluded[id] = true
+	}
+	live := make(map[int32]bool, len(resp.Brokers))
+	for _, b := range resp.Brokers {
+		if !excluded[b.ID()] {
+			live[b.ID()] = true
+			rep.LiveBrokers = append(rep.LiveBrokers, b.ID())
+		}
+	}
+	sort.Slice(rep.LiveBrokers, func(i, j int) bool { return rep.LiveBrokers[i] < rep.LiveBrokers[j] })
+	rep.TooFewBrokers = opts.MinBrokers > 0 && len(rep.LiveBrokers) < opts.MinBrokers
+
+	topics := resp.Topics
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	for _, t := range topics {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		switch {
+		case t.Err == sarama.ErrUnknownTopicOrPartition && len(opts.Topics) > 0:
+			rep.Missing = append(rep.Missing, t.Name)
+			continue
+		case t.Err == sarama.ErrUnknownTopicOrPartition || beingDeleted(t):
+			rep.Deleting = append(rep.Deleting, t.Name)
+			continue
+		case t.Err != sarama.ErrNoError:
+			return nil, fmt.Errorf("error listing partitions of topic %s: %s", t.Name, t.Err)
+		}
+		partitions := t.Partitions
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i].ID < partitions[j].ID })
+		for _, p := range partitions {
+			rep.Checked++
+			assigned := without(p.Replicas, excluded)
+			level := opts.ReplicaLevel - (len(p.Replicas) - len(assigned))
+			isr := without(p.Isr, excluded)
+			counted, _ := Dedup(CountReplicas(opts.CountMode, assigned, isr, func(id int32) bool { return live[id] }))
+			category := PartitionCategory(p.Leader, len(counted), level)
+			if category == "" {
+				continue
+			}
+			rep.Failures = append(rep.Failures, Failure{
+				Topic:     t.Name,
+				Partition: p.ID,
+				Category:  category,
+				Expected:  level,
+				Replicas:  counted,
+				ISR:       isr,
+			})
+		}
+	}
+	rep.Duration = time.Since(start)
+	return rep, nil
+}
+
+// beingDeleted returns true if none of the partitions of the topic has
+// replicas anymore, as the topics marked for deletion
+func beingDeleted(t *sarama.TopicMetadata) bool {
+	for _, p := range t.Partitions {
+		if len(p.Replicas) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// without returns the brokers that are not excluded
+func without(brokers []int32, excluded map[int32]bool) []int32 {
+	if len(excluded) == 0 {
+		return brokers
+	}
+	kept := make([]int32, 0, len(brokers))
+	for _, id := range brokers {
+		if !excluded[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// Valid returns true if m is a known count mode, the empty one counting as
+// CountAssigned
+func (m CountMode) Valid() bool {
+	switch m {
+	case "", CountAssigned, CountISR, CountLive:
+		return true
+	}
+	return false
+}
+
+// CountReplicas returns the replicas of a partition counted against the
+// replica level in the mode, live telling whether a broker is currently part
+// of the cluster. The duplicates are kept, see Dedup
+func CountReplicas(mode CountMode, replicas, isr []int32, live func(id int32) bool) []int32 {
+	switch mode {
+	case CountISR:
+		return isr
+	case CountLive:
+		counted := make([]int32, 0, len(replicas))
+		for _, id := range replicas {
+			if live(id) {
+				counted = append(counted, id)
+			}
+		}
+		return counted
+	default:
+		return replicas
+	}
+}
+
+// PartitionCategory returns the category of the failure of a partition led by
+// leader, with the given number of replicas counted against level, or an
+// empty category if it passes the check. A partition without a leader is
+// always Offline, whatever its replicas, its data is unavailable. A level of
+// 0 or less only requires a leader
+func PartitionCategory(leader int32, replicas, level int) Category {
+	switch {
+	case leader < 0:
+		return Offline
+	case level > 0 && replicas != level:
+		return UnderReplicated
+	}
+	return ""
+}
+
+// Dedup returns the brokers without duplicates, keeping their order, and the
+// brokers that were listed more than once
+func Dedup(brokers []int32) (unique, duplicates []int32) {
+	seen := make(map[int32]bool, len(brokers))
+	unique = make([]int32, 0, len(brokers))
+	for _, id := range brokers {
+		if seen[id] {
+			if !contains(duplicates, id) {
+				duplicates = append(duplicates, id)
+			}
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+	return unique, duplicates
+}
+
+// contains returns true if id is in the list of brokers
+func contains(brokers []int32, id int32) bool {
+	for _, b := range brokers {
+		if b == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package health
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// newTestCluster connects to a mock broker answering the metadata requests
+// with meta, to which the broker is added as the single broker and the
+// controller of the cluster. The cluster speaks Kafka 0.10, so all the
+// metadata requests are v1 and can be answered by a fixed response
+func newTestCluster(t *testing.T, meta *sarama.MetadataResponse) (*Cluster, *sarama.MockBroker) {
+	broker := sarama.NewMockBroker(t, 1)
+	meta.Version = 1
+	meta.ControllerID = broker.BrokerID()
+	meta.AddBroker(broker.Addr(), broker.BrokerID())
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(meta),
+	})
+	c, err := NewCluster([]string{broker.Addr()}, WithVersion(sarama.V0_10_0_0))
+	if err != nil {
+		broker.Close()
+		t.Fatalf("error connecting to the mock broker: %s", err)
+	}
+	return c, broker
+}
+
+func TestCheckReplication(t *testing.T) {
+	meta := &sarama.MetadataResponse{}
+	meta.AddTopicPartition("events", 0, 1, []int32{1, 2}, []int32{1, 2}, sarama.ErrNoError)
+	meta.AddTopicPartition("events", 1, 1, []int32{1, 2}, []int32{1}, sarama.ErrNoError)
+	meta.AddTopicPartition("events", 2, -1, []int32{1, 2}, nil, sarama.ErrNoError)
+	meta.AddTopicPartition("orders", 0, 1, []int32{1}, []int32{1}, sarama.ErrNoError)
+	// a topic without replicas is being deleted
+	meta.AddTopicPartition("deleted", 0, -1, nil, nil, sarama.ErrNoError)
+	c, broker := newTestCluster(t, meta)
+	defer broker.Close()
+	defer c.Close()
+
+	tests := []struct {
+		name string
+		opts ReplicationOptions
+		want []Failure
+	}{
+		{
+			name: "leaders only",
+			opts: ReplicationOptions{},
+			want: []Failure{
+				{Topic: "events", Partition: 2, Category: Offline, Replicas: []int32{1, 2}, ISR: nil},
+			},
+		},
+		{
+			name: "assigned",
+			opts: ReplicationOptions{ReplicaLevel: 2},
+			want: []Failure{
+				{Topic: "events", Partition: 2, Category: Offline, Expected: 2, Replicas: []int32{1, 2}, ISR: nil},
+				{Topic: "orders", Partition: 0, Category: UnderReplicated, Expected: 2, Replicas: []int32{1}, ISR: []int32{1}},
+			},
+		},
+		{
+			name: "isr",
+			opts: ReplicationOptions{ReplicaLevel: 2, CountMode: CountISR},
+			want: []Failure{
+				{Topic: "events", Partition: 1, Category: UnderReplicated, Expected: 2, Replicas: []int32{1}, ISR: []int32{1}},
+				{Topic: "events", Partition: 2, Category: Offline, Expected: 2, Replicas: []int32{}, ISR: nil},
+				{Topic: "orders", Partition: 0, Category: UnderReplicated, Expected: 2, Replicas: []int32{1}, ISR: []int32{1}},
+			},
+		},
+		{
+			// broker 2 is not part of the mock cluster
+			name: "live",
+			opts: ReplicationOptions{ReplicaLevel: 2, CountMode: CountLive},
+			want: []Failure{
+				{Topic: "events", Partition: 0, Category: UnderReplicated, Expected: 2, Replicas: []int32{1}, ISR: []int32{1, 2}},
+				{Topic: "events", Partition: 1, Category: UnderReplicated, Expected: 2, Replicas: []int32{1}, ISR: []int32{1}},
+				{Topic: "events", Partition: 2, Category: Offline, Expected: 2, Replicas: []int32{1}, ISR: nil},
+				{Topic: "orders", Partition: 0, Category: UnderReplicated, Expected: 2, Replicas: []int32{1}, ISR: []int32{1}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep, err := c.CheckReplication(context.Background(), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rep.Failures, tt.want) {
+				t.Errorf("failures = %+v, want %+v", rep.Failures, tt.want)
+			}
+			if rep.Healthy() {
+				t.Error("report with failures is healthy")
+			}
+			if rep.Controller != 1 || !reflect.DeepEqual(rep.LiveBrokers, []int32{1}) {
+				t.Errorf("controller %d and live brokers %v, want 1 and [1]", rep.Controller, rep.LiveBrokers)
+			}
+		})
+	}
+
+	rep, err := c.CheckReplication(context.Background(), ReplicationOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Checked != 4 || !reflect.DeepEqual(rep.Deleting, []string{"deleted"}) {
+		t.Errorf("checked %d partitions and deleting %v, want 4 and [deleted]", rep.Checked, rep.Deleting)
+	}
+}
+
+func TestCheckReplicationOptions(t *testing.T) {
+	meta := &sarama.MetadataResponse{}
+	meta.AddTopicPartition("events", 0, 1, []int32{1, 2}, []int32{1, 2}, sarama.ErrNoError)
+	c, broker := newTestCluster(t, meta)
+	defer broker.Close()
+	defer c.Close()
+
+	// without the excluded broker 2, the level drops to 1
+	rep, err := c.CheckReplication(context.Background(), ReplicationOptions{ReplicaLevel: 2, ExcludedBrokers: []int32{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.Healthy() {
+		t.Errorf("failures %v without the excluded broker", rep.Failures)
+	}
+
+	rep, err = c.CheckReplication(context.Background(), ReplicationOptions{MinBrokers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.TooFewBrokers || rep.Healthy() {
+		t.Errorf("too few brokers %v and healthy %v with 1 live broker out of 2", rep.TooFewBrokers, rep.Healthy())
+	}
+
+	if _, err := c.CheckReplication(context.Background(), ReplicationOptions{CountMode: "all"}); err == nil {
+		t.Error("unknown count mode accepted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.CheckReplication(ctx, ReplicationOptions{}); err != context.Canceled {
+		t.Errorf("check with a canceled context returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCountReplicas(t *testing.T) {
+	// broker 3 is gone, broker 2 is alive but out of sync
+	replicas, isr := []int32{1, 2, 3}, []int32{1}
+	live := func(id int32) bool { return id != 3 }
+	tests := []struct {
+		mode CountMode
+		want []int32
+	}{
+		{"", []int32{1, 2, 3}},
+		{CountAssigned, []int32{1, 2, 3}},
+		{CountISR, []int32{1}},
+		{CountLive, []int32{1, 2}},
+	}
+	for _, tt := range tests {
+		if !tt.mode.Valid() {
+			t.Errorf("count mode %q is not valid", tt.mode)
+		}
+		if got := CountReplicas(tt.mode, replicas, isr, live); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CountReplicas(%q) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+	if CountMode("all").Valid() {
+		t.Error("count mode all is valid")
+	}
+}
+
+func TestPartitionCategory(t *testing.T) {
+	tests := []struct {
+		leader          int32
+		replicas, level int
+		want            Category
+	}{
+		{1, 3, 3, ""},
+		{1, 2, 3, UnderReplicated},
+		{1, 4, 3, UnderReplicated},
+		{1, 1, 0, ""},
+		{-1, 3, 3, Offline},
+		{-1, 0, 0, Offline},
+	}
+	for _, tt := range tests {
+		if got := PartitionCategory(tt.leader, tt.replicas, tt.level); got != tt.want {
+			t.Errorf("PartitionCategory(%d, %d, %d) = %q, want %q", tt.leader, tt.replicas, tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	unique, duplicates := Dedup([]int32{1, 2, 1, 3, 2, 1})
+	if !reflect.DeepEqual(unique, []int32{1, 2, 3}) || !reflect.DeepEqual(duplicates, []int32{1, 2}) {
+		t.Errorf("Dedup() = %v, %v, want [1 2 3], [1 2]", unique, duplicates)
+	}
+}
@@ -0,0 +1,133 @@
+package health
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Cluster is a connection to a Kafka cluster, checked on demand. It is safe
+// for concurrent use
+type Cluster struct {
+	client sarama.Client
+}
+
+// Option configures the connection of NewCluster
+type Option func(*sarama.Config)
+
+// WithConfig starts from a copy of config instead of the defaults of sarama,
+// the options after it apply on top of it
+func WithConfig(config *sarama.Config) Option {
+	return func(c *sarama.Config) {
+		*c = *config
+	}
+}
+
+// WithTLS connects to the brokers with TLS
+func WithTLS(config *tls.Config) Option {
+	return func(c *sarama.Config) {
+		c.Net.TLS.Enable = true
+		c.Net.TLS.Config = config
+	}
+}
+
+// WithVersion sets the version of the Kafka protocol used to talk to the
+// brokers, 1.0.0 by default
+func WithVersion(version sarama.KafkaVersion) Option {
+	return func(c *sarama.Config) {
+		c.Version = version
+	}
+}
+
+// WithDialTimeout sets the timeout of the connection to a broker, 30s by
+// default
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *sarama.Config) {
+		c.Net.DialTimeout = timeout
+	}
+}
+
+// NewCluster connects to the cluster through the bootstrap brokers
+func NewCluster(brokers []string, opts ...Option) (*Cluster, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V1_0_0_0
+	for _, opt := range opts {
+		opt(config)
+	}
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Cluster{client: client}, nil
+}
+
+// Client returns the sarama client of the cluster, for the checks not
+// provided by the package. It is closed by Close
+func (c *Cluster) Client() sarama.Client {
+	return c.client
+}
+
+// Close closes the connections to the brokers
+func (c *Cluster) Close() error {
+	return c.client.Close()
+}
+
+// FetchMetadata fetches the metadata of the given topics, or of all the
+// topics if none are given, from the controller, the most up to date broker.
+// The request never creates the missing topics, from Kafka 0.11. On error,
+// the connection to the controller is closed and the controller is looked up
+// again, so the next call reconnects to the current one
+func FetchMetadata(client sarama.Client, topics []string) (*sarama.MetadataResponse, error) {
+	controller, err := metadataBroker(client)
+	if err != nil {
+		client.RefreshMetadata()
+		return nil, err
+	}
+	resp, err := controller.GetMetadata(&sarama.MetadataRequest{
+		Version:                MetadataVersion(client.Config().Version),
+		Topics:                 topics,
+		AllowAutoTopicCreation: false,
+	})
+	if err != nil {
+		controller.Close()
+		client.RefreshMetadata()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// metadataBroker returns the broker to send the metadata requests to: the
+// controller, or any broker before Kafka 0.10, whose metadata don't tell the
+// controller
+func metadataBroker(client sarama.Client) (*sarama.Broker, error) {
+	if client.Config().Version.IsAtLeast(sarama.V0_10_0_0) {
+		return client.Controller()
+	}
+	brokers := client.Brokers()
+	if len(brokers) == 0 {
+		return nil, sarama.ErrOutOfBrokers
+	}
+	// Open does nothing if the broker is already connected
+	brokers[0].Open(client.Config())
+	return brokers[0], nil
+}
+
+// MetadataVersion returns the highest version of the metadata request
+// supported by the version of Kafka: v1 adds the controller and the rack of
+// the brokers, v2 the cluster ID, v4 the topics not created on request and v5
+// the offline replicas. The fields missing from older versions are left
+// empty, and the controller is -1
+func MetadataVersion(version sarama.KafkaVersion) int16 {
+	switch {
+	case version.IsAtLeast(sarama.V1_0_0_0):
+		return 5
+	case version.IsAtLeast(sarama.V0_11_0_0):
+		return 4
+	case version.IsAtLeast(sarama.V0_10_1_0):
+		return 2
+	case version.IsAtLeast(sarama.V0_10_0_0):
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestMetadataVersion(t *testing.T) {
+	tests := []struct {
+		version sarama.KafkaVersion
+		want    int16
+	}{
+		{sarama.V0_8_2_0, 0},
+		{sarama.V0_10_0_0, 1},
+		{sarama.V0_10_1_0, 2},
+		{sarama.V0_11_0_0, 4},
+		{sarama.V1_0_0_0, 5},
+		{sarama.V2_0_0_0, 5},
+	}
+	for _, tt := range tests {
+		if got := MetadataVersion(tt.version); got != tt.want {
+			t.Errorf("MetadataVersion(%s) = %d, want %d", tt.version, got, tt.want)
+		}
+	}
+}
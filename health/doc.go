@@ -0,0 +1,26 @@
+// Package health checks the replication of the partitions of a Kafka
+// cluster, as a library to embed the check in other Go services:
+//
+//	cluster, err := health.NewCluster([]string{"kafka:9092"}, health.WithDialTimeout(5*time.Second))
+//	if err != nil {
+//		return err
+//	}
+//	defer cluster.Close()
+//	rep, err := cluster.CheckReplication(ctx, health.ReplicationOptions{ReplicaLevel: 3})
+//	if err != nil {
+//		return err
+//	}
+//	if !rep.Healthy() {
+//		...
+//	}
+//
+// The kafka-health command fetches its metadata with FetchMetadata, and
+// counts and classifies the replicas of each partition with CountReplicas,
+// Dedup and PartitionCategory, so both judge the replication of a partition
+// the same way. It runs its own scan around them though, with the checks
+// driven by its flags that are not part of the package.
+//
+// The exported API is stable: new options are only added as new fields of
+// ReplicationOptions, with a zero value keeping the current behavior, or as
+// new Option functions.
+package health
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"fmt"
+	"time"
+)
+
+// Category is the kind of a failure
+type Category string
+
+// categories of the failures, the same as the ones of the kafka-health
+// command
+const (
+	UnderReplicated Category = "under_replicated" // the partition doesn't have the expected number of replicas
+	Offline         Category = "offline"          // the partition has no leader
+)
+
+// Failure is a partition failing the check
+type Failure struct {
+	Topic     string   `json:"topic"`
+	Partition int32    `json:"partition"`
+	Category  Category `json:"category"`
+	Expected  int      `json:"expected"` // replicas required
+	Replicas  []int32  `json:"replicas"` // replicas counted, depending on the CountMode
+	ISR       []int32  `json:"isr"`      // in-sync replicas
+}
+
+func (f Failure) String() string {
+	if f.Category == Offline {
+		return fmt.Sprintf("partition %s:%d has no leader", f.Topic, f.Partition)
+	}
+	return fmt.Sprintf("partition %s:%d has %d replicas instead of %d", f.Topic, f.Partition, len(f.Replicas), f.Expected)
+}
+
+// Report is the result of a check of the cluster
+type Report struct {
+	Cluster       string        `json:"cluster"`       // ID of the cluster
+	Time          time.Time     `json:"time"`          // when the check started
+	Duration      time.Duration `json:"duration"`      // how long the check took
+	LiveBrokers   []int32       `json:"liveBrokers"`   // IDs of the brokers currently part of the cluster, sorted
+	Controller    int32         `json:"controller"`    // ID of the controller, -1 if there is none
+	Checked       int           `json:"checked"`       // number of partitions checked
+	Failures      []Failure     `json:"failures"`      // partitions failing the check, sorted by topic and partition
+	TooFewBrokers bool          `json:"tooFewBrokers"` // fewer brokers than MinBrokers are live
+	Deleting      []string      `json:"deleting"`      // topics being deleted, not checked
+	Missing       []string      `json:"missing"`       // topics asked for that don't exist
+}
+
+// Healthy returns true if no partition fails the check and enough brokers
+// are live
+func (r *Report) Healthy() bool {
+	return len(r.Failures) == 0 && !r.TooFewBrokers && len(r.Missing) == 0
+}
@@ -0,0 +1,37 @@
+package health
+
+import "testing"
+
+func TestFailureString(t *testing.T) {
+	tests := []struct {
+		f    Failure
+		want string
+	}{
+		{Failure{Topic: "events", Partition: 3, Category: Offline}, "partition events:3 has no leader"},
+		{Failure{Topic: "events", Partition: 3, Category: UnderReplicated, Expected: 3, Replicas: []int32{1, 2}}, "partition events:3 has 2 replicas instead of 3"},
+	}
+	for _, tt := range tests {
+		if got := tt.f.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestReportHealthy(t *testing.T) {
+	tests := []struct {
+		name string
+		rep  Report
+		want bool
+	}{
+		{"empty", Report{}, true},
+		{"failure", Report{Failures: []Failure{{Category: Offline}}}, false},
+		{"too few brokers", Report{TooFewBrokers: true}, false},
+		{"missing topic", Report{Missing: []string{"events"}}, false},
+		{"deleting topic", Report{Deleting: []string{"events"}}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.rep.Healthy(); got != tt.want {
+			t.Errorf("%s: Healthy() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+// internalTopic is an internal topic of Kafka that can be checked explicitly,
+// with its own replica level, and reported as a named component
+type internalTopic struct {
+	Component string // name of the component in the report
+	Topic     string
+	enabled   *bool // the topic is checked explicitly
+	level     *int  // replica level required for the topic, replicaLevel if 0
+	count     *int  // number of partitions required for the topic, not checked if nil or 0
+}
+
+var internalTopics = []internalTopic{
+	{Component: "transactionState", Topic: "__transaction_state", enabled: checkTxState, level: txStateLevel},
+	{Component: "consumerOffsets", Topic: "__consumer_offsets", enabled: checkOffsets, level: offsetsLevel, count: offsetsCount},
+}
+
+// component summarizes the health of an internal topic checked explicitly
+type component struct {
+	Name       string `json:"name"`
+	Topic      string `json:"topic"`
+	Exists     bool   `json:"exists"`     // Kafka only creates the internal topics when they are first used
+	Expected   int    `json:"expected"`   // replica level required for the topic
+	Partitions int    `json:"partitions"` // number of partitions checked
+	Unhealthy  int    `json:"unhealthy"`  // number of partitions with at least one failure
+	Count      int    `json:"count"`      // number of partitions of the topic
+	Wanted     int    `json:"wanted"`     // number of partitions required for the topic, 0 if not checked
+}
+
+// Healthy returns true if none of the partitions of the component failed, and
+// it has the number of partitions required
+func (c *component) Healthy() bool {
+	return c.Unhealthy == 0 && !c.miscounted()
+}
+
+// miscounted returns true if the topic doesn't have the number of partitions
+// required
+func (c *component) miscounted() bool {
+	return c.Wanted > 0 && c.Count != c.Wanted
+}
+
+// enabledInternalTopics returns the internal topics checked explicitly
+func enabledInternalTopics() []internalTopic {
+	var enabled []internalTopic
+	for _, t := range internalTopics {
+		if *t.enabled {
+			enabled = append(enabled, t)
+		}
+	}
+	return enabled
+}
+
+// findInternalTopic returns the internal topic named topic, if it is checked
+// explicitly
+func findInternalTopic(topic string) (internalTopic, bool) {
+	for _, t := range enabledInternalTopics() {
+		if t.Topic == topic {
+			return t, true
+		}
+	}
+	return internalTopic{}, false
+}
+
+// expectedReplicas returns the replica level required for the topic: the one
+// of the internal topic checked explicitly, else the one of its tier, else its
+// replication factor with -minReplicaLevelFromConfig, else the replicaLevel.
+// ts is nil if the topic is unknown
+func expectedReplicas(topic string, ts *topicState, tiers []replicaTier) int {
+	if t, ok := findInternalTopic(topic); ok && *t.level > 0 {
+		return *t.level
+	}
+	if i := findTier(tiers, topic); i >= 0 {
+		return tiers[i].ReplicaLevel
+	}
+	if *levelFromRF && ts != nil {
+		if rf := ts.replicationFactor(); rf > 0 {
+			return rf
+		}
+	}
+	return *replicaLevel
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// fetchAPIKey is the key of the Fetch request in the ApiVersions response
+const fetchAPIKey = 1
+
+// kafkaVersions maps the highest version of the Fetch request supported by a
+// broker to the Kafka release that introduced it, the newest first. The Fetch
+// request gets a new version with most of the releases
+var kafkaVersions = []struct {
+	fetch   int16
+	version sarama.KafkaVersion
+}{
+	{8, sarama.V2_0_0_0},
+	{7, sarama.V1_1_0_0},
+	{6, sarama.V1_0_0_0},
+	{4, sarama.V0_11_0_0},
+	{3, sarama.V0_10_1_0},
+	{2, sarama.V0_10_0_0},
+}
+
+// detectKafkaVersion asks the brokers, in turn, for the versions of the API
+// they support, and returns the Kafka version of the first one answering.
+// Versions newer than the ones known by sarama are capped to its MaxVersion
+func detectKafkaVersion(brokers []string, config *sarama.Config) (sarama.KafkaVersion, error) {
+	// ApiVersions is available since 0.10.0, and only needs a connection
+	minimal := *config
+	minimal.Version = sarama.V0_10_0_0
+
+	err := errors.New("no broker to connect to")
+	for _, addr := range brokers {
+		var resp *sarama.ApiVersionsResponse
+		b := sarama.NewBroker(addr)
+		if err = b.Open(&minimal); err != nil {
+			continue
+		}
+		resp, err = b.ApiVersions(&sarama.ApiVersionsRequest{})
+		b.Close()
+		if err != nil {
+			continue
+		}
+		if resp.Err != sarama.ErrNoError {
+			err = resp.Err
+			continue
+		}
+		return kafkaVersionOf(resp)
+	}
+	return sarama.MinVersion, err
+}
+
+// kafkaVersionOf returns the Kafka version matching the API versions
+// supported by a broker
+func kafkaVersionOf(resp *sarama.ApiVersionsResponse) (sarama.KafkaVersion, error) {
+	for _, api := range resp.ApiVersions {
+		if api.ApiKey != fetchAPIKey {
+			continue
+		}
+		for _, v := range kafkaVersions {
+			if api.MaxVersion >= v.fetch {
+				return v.version, nil
+			}
+		}
+		return sarama.MinVersion, fmt.Errorf("unknown Fetch version %d", api.MaxVersion)
+	}
+	return sarama.MinVersion, errors.New("the broker doesn't support the Fetch request")
+}
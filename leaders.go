@@ -0,0 +1,25 @@
+package main
+
+import "fmt"
+
+// leaderStats reports the partitions whose leader is not their preferred
+// replica, the first of the assigned ones. They stay so after a broker outage
+// until a preferred replica election is run, when auto.leader.rebalance.enable
+// is off
+type leaderStats struct {
+	NonPreferred []string `json:"nonPreferred"` // topic:partition of the partitions led by another replica
+	Percent      float64  `json:"percent"`      // percentage of the checked partitions with a leader that are led by another replica
+	MaxPercent   float64  `json:"maxPercent"`   // percentage above which the check fails
+	Failed       bool     `json:"failed"`       // Percent exceeds MaxPercent
+}
+
+// hasPreferredLeader returns true if the partition is led by its preferred
+// replica. A partition without leader is reported as offline instead
+func hasPreferredLeader(p partitionState) bool {
+	return p.Leader < 0 || len(p.Replicas) == 0 || p.Leader == p.Replicas[0]
+}
+
+// partitionName returns the topic:partition name of a partition
+func partitionName(topic string, partition int32) string {
+	return fmt.Sprintf("%s:%d", topic, partition)
+}
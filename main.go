@@ -1,20 +1,156 @@
 package main
 
 import (
+	"io"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/namsral/flag"
+	"github.com/prune998/kafka-health/health"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 var (
-	logLevel     = flag.String("logLevel", logrus.WarnLevel.String(), "the log level to display")
-	broker       = flag.String("broker", "localhost:9092", "The comma separated list of brokers in the Kafka cluster including port")
-	topics       = flag.String("topics", "", "REQUIRED: limit the list of topics to be checked for replication")
-	replicaLevel = flag.Int("replicaLevel", 2, "Replication Level required to be OK")
-	version      = "no version set"
+	logLevel         = flag.String("logLevel", logrus.WarnLevel.String(), "the log level to display")
+	name             = flag.String("name", "", "name of the health check, added to the logs and the report to tell apart several deployments. kafka-health@hostname if empty")
+	logCallerF       = flag.Bool("logCaller", false, "add the source location of the code logging to the logs")
+	broker           = flag.String("broker", "localhost:9092", "The comma separated list of brokers in the Kafka cluster including port")
+	topics           = flag.String("topics", "", "REQUIRED: limit the list of topics to be checked for replication")
+	partitions       = flag.String("partitions", "", "only check these partitions of the listed topics, as topic:partition,partition;topic:partition... (ex: orders:0,1,5)")
+	replicaLevel     = flag.Int("replicaLevel", 2, "Replication Level required to be OK")
+	levelFromRF      = flag.Bool("minReplicaLevelFromConfig", false, "require each topic to have as many replicas as its replication factor, the number of replicas assigned to most of its partitions, instead of replicaLevel. The replicaTiers and the internal topics keep their level")
+	tiersFile        = flag.String("replicaTiers", "", "JSON file of the replica tiers, each with a name, a replicaLevel and a regular expression matching its topics")
+	checkMinISR      = flag.Bool("checkMinInsyncReplicas", false, "report the partitions with fewer in-sync replicas than the min.insync.replicas of their topic, rejecting acks=all producers")
+	leaderInRep      = flag.Bool("failOnReplicaLeaderOnSameBroker", false, "report the partitions whose leader is not one of their assigned replicas, as left by a broken reassignment, as leader_not_in_replicas")
+	checkOutOfSync   = flag.Bool("checkOutOfSyncReplicas", false, "report the partitions with assigned replicas out of their ISR, whatever the replicaLevel, as out_of_sync")
+	checkOverRep     = flag.Bool("checkOverReplication", false, "report the partitions with more replicas assigned than the replication factor of their topic")
+	compareLeader    = flag.Bool("compareReplicasAcrossLeaderAndMetadata", false, "query the metadata from the leader of each partition, and report the partitions it disagrees on with the controller")
+	antiAffinity     = flag.Bool("requireReplicaAntiAffinity", false, "report the partitions with replicas sharing a rack or a host")
+	verifyMeta       = flag.Bool("verifyMetadataConsistency", false, "query the metadata from each broker, and report the partitions they disagree on")
+	maxCompactedSpan = flag.Int64("maxCompactedSpan", 0, "warn about the partitions of the compacted topics whose log spans more than this number of offsets, as their compaction may be lagging. 0 to disable, it costs 2 requests per partition")
+	maxTopics        = flag.Int("maxConcurrentTopics", 2, "with maxCompactedSpan, number of topics whose offsets are fetched at once")
+	maxPartitions    = flag.Int("maxConcurrentPartitions", 4, "with maxCompactedSpan, number of partitions of a topic whose offsets are fetched at once")
+	isrGrace         = flag.Duration("isrGracePeriod", 0, "check the partitions not fully replicated again after this period, and only report those still not fully replicated. 0 to disable")
+	countMode        = flag.String("replicaCountMode", "assigned", "which replicas are counted against replicaLevel: assigned, isr or live")
+	maxFailures      = flag.Int("maxFailuresToReport", 0, "maximum number of failing partitions detailed in the output, 0 for unlimited")
+	failPercent      = flag.Float64("failThresholdPercent", 0, "only fail when more than this percentage of the checked partitions are unhealthy")
+	failCount        = flag.Int("failThresholdCount", 0, "always fail when at least this number of partitions are unhealthy, regardless of failThresholdPercent. 0 to disable")
+	maxNonPreferred  = flag.Float64("maxNonPreferredLeaderPercent", -1, "fail when more than this percentage of the partitions are not led by their preferred replica. -1 to disable")
+	leaderTopics     = flag.String("leaderBalanceTopics", "", "comma separated list of hot topics whose leadership must be evenly spread over the brokers hosting their replicas")
+	maxLeaderRatio   = flag.Float64("maxLeaderImbalance", 1.5, "with leaderBalanceTopics, fail when the broker leading the most partitions of a topic leads more than this ratio of the mean")
+	maxPreferred     = flag.Float64("maxPreferredLeaderImbalance", 0, "fail when a topic has more partitions preferring the same broker as leader, their first replica, than this ratio of an even spread (ex: 1.5). 0 to disable")
+	maxImbalance     = flag.Float64("maxReplicaImbalance", 0, "fail when the broker with the most replicas has more than this ratio of the mean number of replicas by broker (ex: 1.2). 0 to disable")
+	maxCtrlChanges   = flag.Int("maxControllerChanges", 0, "serve mode: fail when the controller changed more than this number of times within controllerChangesWindow. 0 to disable, the changes are still logged")
+	ctrlWindow       = flag.Duration("controllerChangesWindow", time.Hour, "serve mode: window over which the changes of controller are counted against maxControllerChanges")
+	escalateAfter    = flag.Int("escalateAfter", 0, "serve mode: escalate the WARN failures of the partitions failing this number of consecutive scans to CRITICAL. 0 to disable")
+	leaderCritical   = flag.Bool("leaderUnavailableIsCritical", false, "always fail the check with exit code 3 when a partition has no leader, overriding every other threshold and the HTTP state")
+	failDrift        = flag.Bool("failIfTopicsAppearedOrDisappeared", false, "fail the check when topics are created or deleted, compared to the topicsAllowList, or to the topics of the first scan in serve mode")
+	allowList        = flag.String("topicsAllowList", "", "with failIfTopicsAppearedOrDisappeared, file of the expected topics, one by line. Lines starting with # are comments")
+	failDeleting     = flag.Bool("failOnDeleting", false, "fail the check when topics are being deleted, instead of only reporting them")
+	convention       = flag.String("nameConvention", "", "regular expression all the topic names must match, the others are reported")
+	auditRF1         = flag.Bool("auditSingleReplica", false, "report every partition with a single replica assigned, by topic, whatever the replicaLevel")
+	failRF1          = flag.Bool("failOnSingleReplica", false, "with auditSingleReplica, fail the check when partitions have a single replica, instead of only reporting them")
+	failBadName      = flag.Bool("failOnBadName", false, "fail the check when topics don't match the nameConvention, instead of only reporting them")
+	summaryTable     = flag.Bool("summaryTable", false, "print a table of the partitions by severity and failure category at the end of the run")
+	checkTxState     = flag.Bool("checkTransactionState", false, "always check the __transaction_state topic, and report it as a component")
+	txStateLevel     = flag.Int("transactionStateReplicaLevel", 0, "Replication Level required for __transaction_state, replicaLevel if 0")
+	checkOffsets     = flag.Bool("checkConsumerOffsets", false, "always check the __consumer_offsets topic, and report it as a component")
+	offsetsLevel     = flag.Int("consumerOffsetsReplicaLevel", 0, "Replication Level required for __consumer_offsets, replicaLevel if 0")
+	offsetsCount     = flag.Int("expectedOffsetsPartitions", 50, "with checkConsumerOffsets, number of partitions __consumer_offsets must have, as set by offsets.topic.num.partitions. 0 to disable")
+	minBrokers       = flag.Int("minBrokers", 0, "fail when fewer than this number of brokers are live, whatever the health of the topics. 0 to disable")
+	brokerID         = flag.Int("brokerID", -1, "only check the partitions with a replica on this broker, and summarize its role")
+	excludeTopics    = flag.String("excludeTopics", "", "regular expression of the topics left out of the checks, matching the whole topic name")
+	excludeFile      = flag.String("excludeTopicsFile", "", "file of the topics left out of the checks, one exact name or regular expression by line, merged with excludeTopics. Lines starting with # are comments")
+	redactTopics     = flag.String("redactTopics", "", "regular expression of the sensitive topic names, masked with a stable hash in the logs and the reports. It must match the whole topic name")
+	excludeBrokers   = flag.String("excludeBrokers", "", "comma separated list of broker IDs ignored by the checks, as if they were not part of the cluster, ex: during a planned decommission")
+	httpAddr         = flag.String("httpAddr", "", "serve mode: scan every scanInterval and serve the results over HTTP on this address (ex: :8080)")
+	scanInterval     = flag.Duration("scanInterval", 30*time.Second, "serve mode: interval between two scans")
+	pollJitter       = flag.Float64("pollJitter", 0, "serve mode: delay the periodic scans by a random offset, up to this fraction of the scanInterval, to spread the load of several instances")
+	jitterSeed       = flag.String("pollJitterSeed", "", "serve mode: seed of the random offset of the scans, the hostname if empty")
+	emaAlpha         = flag.Float64("scanDurationAlpha", 0.3, "serve mode: weight of the last scan in the moving average of the scan durations, between 0 and 1")
+	resumeFrom       = flag.String("resumeFrom", "", "skip the topics sorted up to and including this one, to resume an interrupted scan")
+	checkpointF      = flag.String("checkpointFile", "", "periodically write the last topic completely scanned to this file, to resume with -resumeFrom")
+	checkpointI      = flag.Duration("checkpointInterval", 5*time.Second, "minimum interval between two writes of the checkpointFile")
+	healthFile       = flag.String("partitionHealthFile", "", "JSON file recording when each partition was last seen healthy, updated on each run")
+	unhealthyFor     = flag.Duration("unhealthyFor", time.Hour, "with partitionHealthFile, the failures of the partitions not seen healthy for this long are PERSISTENT")
+	output           = flag.String("output", "", "comma separated list of the formats of the report, each written to stdout or to its own file as format:path (ex: text,json:report.json): json, text, csv, ndjson, graphite or nagios. Inferred from the extension of outputFile if empty")
+	graphitePrefix   = flag.String("graphitePrefix", "kafka.health", "prefix of the metrics written with -output=graphite, followed by the cluster ID")
+	outputFile       = flag.String("outputFile", "", "write the report to this file instead of stdout, in the single format of output")
+	csvHealthy       = flag.Bool("csvIncludeHealthy", true, "write the csv header even when there is no failure, nothing is written otherwise")
+	pushgateway      = flag.String("pushgatewayURL", "", "push the metrics in the Prometheus text format to this Pushgateway after each scan (ex: http://pushgateway:9091), replacing the group of pushJob and pushInstance")
+	pushJob          = flag.String("pushJob", "kafka-health", "with pushgatewayURL, job label of the group of the metrics")
+	pushInstance     = flag.String("pushInstance", "", "with pushgatewayURL, instance label of the group of the metrics, the hostname if empty")
+	syslogAddr       = flag.String("syslogAddr", "", "also send the summary of the failing scans to syslog: local for the local daemon, or network://host:port for a remote server, the network being udp (default), tcp or unix")
+	syslogFacility   = flag.String("syslogFacility", "daemon", "syslog facility of the messages sent to syslogAddr: kern, user, mail, daemon, auth, syslog or local0 to local7")
+	webhookURL       = flag.String("webhookURL", "", "post the failures and the report of each scan to this URL as CloudEvents, in the structured JSON mode")
+	webhookRetries   = flag.Int("webhookRetries", 3, "with webhookURL, number of retries of an event on a network or server error")
+	webhookBackoff   = flag.Duration("webhookBackoff", time.Second, "with webhookURL, wait before the first retry of an event, doubled on each retry")
+	metricsFile      = flag.String("metricsTextfile", "", "write the metrics in the Prometheus text format to this file after each scan, for the textfile collector of node_exporter")
+	timeTopics       = flag.Bool("timeTopics", false, "log how long each topic took to check, and the slowest ones at the end of the scan, at info level")
+	slowestTopics    = flag.Int("slowestTopics", 10, "with timeTopics, number of the slowest topics logged at the end of the scan")
+	onListError      = flag.String("onListError", listErrorFail, "what to do with a topic whose partitions can't be listed: fail the scan, or skip it and report it as inconclusive")
+	topicTimeout     = flag.Duration("perTopicTimeout", 0, "with maxCompactedSpan, stop fetching the offsets of a topic after this time, and report it as timed out, so a pathological topic doesn't hold the whole scan. The other checks send no request by partition and are not bounded. 0 to disable")
+	scanTimeout      = flag.Duration("scanTimeout", 0, "stop a scan after this time, and fail it. In serve mode, the next scan runs as usual. 0 to disable")
+	probeAll         = flag.Bool("probeAllBrokers", false, "connect to every live broker, all at once, before checking the partitions, and report the brokers that can't be reached and how long each connection took")
+	canaryTopic      = flag.String("canaryTopic", "", "produce a timestamped message to this topic and consume it back on each scan, failing the check when the round trip doesn't complete within canaryTimeout. Disabled if empty")
+	canaryTimeout    = flag.Duration("canaryTimeout", 10*time.Second, "with canaryTopic, maximum round trip of the canary message, from the produce request to the message consumed back")
+	canaryCreate     = flag.Bool("canaryCreateTopic", false, "with canaryTopic, create the topic when the cluster doesn't have it, with canaryPartitions, canaryReplicationFactor and canaryRetention")
+	canaryParts      = flag.Int("canaryPartitions", 0, "with canaryCreateTopic, number of partitions of the canary topic, the number of live brokers if 0")
+	canaryRF         = flag.Int("canaryReplicationFactor", 0, "with canaryCreateTopic, replication factor of the canary topic, replicaLevel if 0")
+	canaryRetention  = flag.Duration("canaryRetention", time.Hour, "with canaryCreateTopic, retention of the messages of the canary topic. The default of the brokers if 0")
+	canaryCleanup    = flag.String("canaryCleanup", canaryCleanupNone, "with canaryTopic, what to do with the topic when the process stops: delete it, truncate it to delete its messages, or leave it if empty")
+	rateLimit        = flag.Float64("rateLimit", 0, "maximum number of requests per second sent to the brokers by a scan, 0 for unlimited")
+	useTLS           = flag.Bool("tls", false, "connect to the brokers with TLS")
+	tlsCAFile        = flag.String("tlsCAFile", "", "with tls, PEM bundle of the CA certificates the certificates of the brokers are verified against, the CAs of the system if empty")
+	tlsSkipVerify    = flag.Bool("tlsInsecureSkipVerify", false, "with tls, don't verify the certificates of the brokers. Insecure, for testing only")
+	tlsCertFile      = flag.String("tlsCertFile", "", "with tls, PEM file of the client certificate presented to the brokers requiring mutual TLS, with tlsKeyFile or tlsKeyPEM")
+	tlsKeyFile       = flag.String("tlsKeyFile", "", "with tls, PEM file of the private key of the client certificate")
+	tlsCertPEM       = flag.String("tlsCertPEM", "", "with tls, client certificate presented to the brokers requiring mutual TLS, as inline PEM, instead of tlsCertFile")
+	tlsKeyPEM        = flag.String("tlsKeyPEM", "", "with tls, private key of the client certificate, as inline PEM, instead of tlsKeyFile. Best set from the TLSKEYPEM environment variable")
+	tlsMinVersion    = flag.String("tlsMinVersion", "", "minimum TLS version of the connections to the brokers: 1.0, 1.1, 1.2 or 1.3. Go's default if empty")
+	tlsCiphers       = flag.String("tlsCipherSuites", "", "comma separated list of the cipher suites allowed up to TLS 1.2, by IANA name. Go's secure defaults if empty")
+	saslMechanism    = flag.String("saslMechanism", "", "authenticate to the brokers with this SASL mechanism: PLAIN. Disabled if empty")
+	saslUser         = flag.String("saslUser", "", "with saslMechanism, user to authenticate as")
+	saslPassword     = flag.String("saslPassword", "", "with saslMechanism, password of the saslUser. Best set from the SASLPASSWORD environment variable")
+	kafkaVersion     = flag.String("kafkaVersion", "1.0.0", "version of the Kafka protocol used to talk to the brokers")
+	detectVersion    = flag.Bool("kafkaVersionAutoDetect", false, "detect the version of the Kafka protocol from the brokers, kafkaVersion is used if it fails")
+	metaRetries      = flag.Int("metadataRetries", 3, "number of times the sarama client retries a metadata request when the cluster is in the middle of a leader election")
+	metaBackoff      = flag.Duration("metadataRetryBackoff", 250*time.Millisecond, "time the sarama client waits between two retries of a metadata request")
+	connectTimeout   = flag.Duration("connectTimeout", 30*time.Second, "timeout of the connection to a broker")
+	printRacksF      = flag.Bool("printRacks", false, "print the rack label of each live broker, as seen in the metadata, and exit without checking anything")
+	dumpMeta         = flag.Bool("dumpMetadata", false, "print the cluster metadata seen by the checks as JSON, and exit without checking anything")
+	printConf        = flag.String("printConfig", "", "print the resolved value of every setting as a command line, secrets redacted, then continue to run the check or exit")
+	dockerCheck      = flag.Bool("dockerHealthcheck", false, "run as a Docker HEALTHCHECK: fail with exit code 1 if the check doesn't complete within healthcheckTimeout, and exit with 1 instead of the other non-zero codes, reserved by Docker")
+	checkTimeout     = flag.Duration("healthcheckTimeout", 25*time.Second, "with dockerHealthcheck, maximum time of the whole check, connection included. Keep it below the timeout of the HEALTHCHECK, 30s by default")
+	interactive      = flag.Bool("interactive", false, "start an interactive shell on stdin to inspect the cluster, instead of checking it")
+	saramaDebug      = flag.Bool("saramaDebug", false, "log the internal logs of the sarama client, at debug level")
+	baselineFile     = flag.String("baseline", "", "JSON report of a previous run: only the failures that are not in it fail the check")
+	baselinePolicy   = flag.String("baselinePolicy", policyNew, "which differences with the baseline fail the check: new, or changed to also fail when the replicas of a known failure changed")
+	acls             = flag.String("aclAssertions", "", "comma separated list of ACLs expected to exist, as principal:operation:resourceType:resourceName (ex: User:alice:Read:Topic:orders)")
+	version          = "no version set"
+)
+
+// splitList splits a comma separated list, trimming the spaces around the items
+// and ignoring the empty ones. It returns nil if there is no item
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// exit codes of a failed check
+const (
+	exitUnhealthy    = 1 // some partitions are not healthy, or ACLs are missing
+	exitTooFewBroker = 2 // fewer brokers than minBrokers are live
+	exitNoLeader     = 3 // partitions have no leader, with leaderUnavailableIsCritical
 )
 
 func main() {
@@ -28,81 +164,490 @@ func main() {
 		myLogLevel = logrus.WarnLevel
 	}
 	log.SetLevel(myLogLevel)
+	handleLogSignals(log, myLogLevel)
+	if *logCallerF {
+		log.AddHook(callerHook{})
+	}
+	if *name == "" {
+		hostname, _ := os.Hostname()
+		*name = "kafka-health@" + hostname
+	}
+	log.AddHook(nameHook{name: *name})
+	if *pushInstance == "" {
+		*pushInstance, _ = os.Hostname()
+	}
 
 	// Output to stdout instead of the default stderr
 	log.SetOutput(os.Stdout)
 
+	// print the effective config before anything can fail, to reproduce the run
+	switch *printConf {
+	case "":
+	case printConfigExit:
+		printConfig(os.Stdout)
+		return
+	case printConfigContinue:
+		printConfig(os.Stderr)
+	default:
+		log.Fatalf("invalid printConfig %q, must be one of continue or exit", *printConf)
+	}
+
+	// as a Docker HEALTHCHECK, a check still running when Docker gives up
+	// on it would be killed without a result: fail it before
+	if *dockerCheck {
+		if *httpAddr != "" || *interactive {
+			log.Fatal("dockerHealthcheck only applies to the one-shot check, use /healthcheck in serve mode")
+		}
+		if *checkTimeout <= 0 {
+			log.Fatalf("invalid healthcheckTimeout %s, must be positive", *checkTimeout)
+		}
+		failAfter(*checkTimeout, log)
+	}
+
+	if *replicaLevel < 0 || *txStateLevel < 0 || *offsetsLevel < 0 {
+		log.Fatalf("invalid replicaLevel %d, transactionStateReplicaLevel %d or consumerOffsetsReplicaLevel %d, must be 0 or more", *replicaLevel, *txStateLevel, *offsetsLevel)
+	}
+
+	if !validCountMode(*countMode) {
+		log.Fatalf("invalid replicaCountMode %q, must be one of assigned, isr or live", *countMode)
+	}
+	if *onListError != listErrorFail && *onListError != listErrorSkip {
+		log.Fatalf("invalid onListError %q, must be one of fail or skip", *onListError)
+	}
+
+	assertions, err := parseACLAssertions(*acls)
+	if err != nil {
+		log.Fatalf("invalid aclAssertions: %s", err)
+	}
+
+	if *pollJitter < 0 || *pollJitter > 1 {
+		log.Fatalf("invalid pollJitter %v, must be between 0 and 1", *pollJitter)
+	}
+
+	if *maxTopics < 1 || *maxPartitions < 1 {
+		log.Fatalf("invalid maxConcurrentTopics %d or maxConcurrentPartitions %d, must be 1 or more", *maxTopics, *maxPartitions)
+	}
+
+	if *maxCtrlChanges < 0 || *ctrlWindow <= 0 {
+		log.Fatalf("invalid maxControllerChanges %d or controllerChangesWindow %s, must be 0 or more, and more than 0", *maxCtrlChanges, *ctrlWindow)
+	}
+
+	if *emaAlpha <= 0 || *emaAlpha > 1 {
+		log.Fatalf("invalid scanDurationAlpha %v, must be greater than 0 and at most 1", *emaAlpha)
+	}
+
+	if !validBaselinePolicy(*baselinePolicy) {
+		log.Fatalf("invalid baselinePolicy %q, must be one of new or changed", *baselinePolicy)
+	}
+	var baseline *report
+	if *baselineFile != "" {
+		baseline, err = loadBaseline(*baselineFile)
+		if err != nil {
+			log.Fatalf("invalid baseline: %s", err)
+		}
+		if baseline.Truncated {
+			log.Warnf("baseline %s is truncated, the failures left out of it are reported as new", *baselineFile)
+		}
+	}
+
+	var tiers []replicaTier
+	if *tiersFile != "" {
+		tiers, err = loadReplicaTiers(*tiersFile)
+		if err != nil {
+			log.Fatalf("invalid replicaTiers: %s", err)
+		}
+	}
+
+	excluded, err := parseBrokerIDs(*excludeBrokers)
+	if err != nil {
+		log.Fatalf("invalid excludeBrokers: %s", err)
+	}
+
+	excludedTopics, err := newTopicExclusion(*excludeTopics, *excludeFile)
+	if err != nil {
+		log.Fatalf("invalid excludeTopics or excludeTopicsFile: %s", err)
+	}
+
+	redactor, err := newTopicRedactor(*redactTopics)
+	if err != nil {
+		log.Fatalf("invalid redactTopics: %s", err)
+	}
+	if redactor != nil {
+		log.AddHook(redactHook{redactor: redactor})
+	}
+
+	if *failDrift && *topics != "" {
+		log.Fatalf("failIfTopicsAppearedOrDisappeared watches all the topics of the cluster, it can't be used with topics")
+	}
+	syslogW, err := newSyslogWriter(*syslogAddr, *syslogFacility, log)
+	if err != nil {
+		log.Fatalf("invalid syslogAddr or syslogFacility: %s", err)
+	}
+	if *canaryTopic != "" && *canaryTimeout <= 0 {
+		log.Fatalf("invalid canaryTimeout %s, must be positive", *canaryTimeout)
+	}
+	if *canaryParts < 0 || *canaryRF < 0 || *canaryRetention < 0 {
+		log.Fatalf("invalid canaryPartitions %d, canaryReplicationFactor %d or canaryRetention %s, must be 0 or more", *canaryParts, *canaryRF, *canaryRetention)
+	}
+	if *canaryCreate && *canaryRF == 0 && *replicaLevel == 0 {
+		log.Fatal("canaryReplicationFactor is required with canaryCreateTopic when replicaLevel is 0")
+	}
+	switch *canaryCleanup {
+	case canaryCleanupNone, canaryCleanupDelete, canaryCleanupTruncate:
+	default:
+		log.Fatalf("invalid canaryCleanup %q, must be one of delete or truncate", *canaryCleanup)
+	}
+	if *webhookRetries < 0 {
+		log.Fatalf("invalid webhookRetries %d, must be 0 or more", *webhookRetries)
+	}
+
+	var knownTopics map[string]bool
+	if *allowList != "" {
+		names, err := readListFile(*allowList)
+		if err != nil {
+			log.Fatalf("invalid topicsAllowList: %s", err)
+		}
+		knownTopics = make(map[string]bool, len(names))
+		for _, name := range names {
+			knownTopics[name] = true
+		}
+	}
+
+	var nameConvention *regexp.Regexp
+	if *convention != "" {
+		nameConvention, err = regexp.Compile(*convention)
+		if err != nil {
+			log.Fatalf("invalid nameConvention: %s", err)
+		}
+	}
+
+	clusterVersion, err := sarama.ParseKafkaVersion(*kafkaVersion)
+	if err != nil {
+		log.Fatalf("invalid kafkaVersion: %s", err)
+	}
+
+	if !*useTLS && (*tlsCAFile != "" || *tlsSkipVerify) {
+		log.Fatal("tlsCAFile and tlsInsecureSkipVerify only apply with tls")
+	}
+	tlsConfig, err := newTLSConfig(*tlsMinVersion, splitList(*tlsCiphers), *tlsCAFile, *tlsSkipVerify)
+	if err != nil {
+		log.Fatalf("invalid TLS config: %s", err)
+	}
+	if *tlsSkipVerify {
+		log.Warn("the certificates of the brokers are not verified, tlsInsecureSkipVerify is set")
+	}
+	clientCert, err := loadClientCertificate(*tlsCertFile, *tlsKeyFile, *tlsCertPEM, *tlsKeyPEM)
+	if err != nil {
+		log.Fatalf("invalid client certificate: %s", err)
+	}
+	if clientCert != nil {
+		if !*useTLS {
+			log.Fatal("the client certificate only applies with tls")
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, *clientCert)
+	}
+
+	partitionFilter, err := parsePartitionFilter(*partitions)
+	if err != nil {
+		log.Fatalf("invalid partitions: %s", err)
+	}
+
+	outputs, err := parseOutputs(*output, *outputFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *httpAddr != "" && len(outputs) > 0 {
+		log.Fatal("output and outputFile only apply to the one-shot check, use the HTTP endpoints in serve mode")
+	}
+	// a Nagios plugin prints a single line, the logs go elsewhere
+	for _, o := range outputs {
+		if o.format == formatNagios && o.path == "" {
+			log.SetOutput(os.Stderr)
+		}
+	}
+	if *dockerCheck && hasNagiosOutput(outputs) {
+		log.Fatal("dockerHealthcheck and the nagios output both set the exit codes, use one of them")
+	}
+
 	log.WithFields(logrus.Fields{
 		"version": version,
 		"brokers": *broker}).Info("starting app")
 
 	// split brokers and topics
+	// if no topic is provided, all the topics of the cluster are checked
 	brokersList := strings.Split(*broker, ",")
-	topicsList := strings.Split(*topics, ",")
+	topicsList := splitList(*topics)
+
+	// the topics named on the command line are masked in the logs from now
+	// on, not only once the first snapshot lists them
+	redactor.learnNames(topicsList...)
+	redactor.learnNames(splitList(*leaderTopics)...)
+	for topic := range partitionFilter {
+		redactor.learnNames(topic)
+	}
+	if *canaryTopic != "" {
+		redactor.learnNames(*canaryTopic)
+	}
+
+	// sarama discards its own logs by default
+	if *saramaDebug {
+		sarama.Logger = newSaramaLogger(log)
+	}
 
 	// init (custom) config, enable errors and notifications
 	config := sarama.NewConfig()
 	config.Consumer.Return.Errors = true
-	config.Version = sarama.V1_0_0_0
+	config.Version = clusterVersion
+	config.Net.TLS.Enable = *useTLS
+	if *useTLS {
+		config.Net.TLS.Config = tlsConfig
+	}
+	config.Metadata.Retry.Max = *metaRetries
+	config.Metadata.Retry.Backoff = *metaBackoff
+	config.Net.DialTimeout = *connectTimeout
+	if err := configureSASL(config, *saslMechanism, *saslUser, *saslPassword); err != nil {
+		log.Fatalf("invalid SASL config: %s", err)
+	}
+	if config.Net.SASL.Enable && !*useTLS {
+		log.Warnf("the SASL %s password is sent in clear text, without tls", *saslMechanism)
+	}
+	// the canary is produced with acks=all, and waited for
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+
+	// ask the brokers which version they speak, when asked to
+	if *detectVersion {
+		detected, err := detectKafkaVersion(brokersList, config)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"err":     err,
+				"version": config.Version.String(),
+			}).Warn("can't detect the Kafka version, using kafkaVersion")
+		} else {
+			log.WithFields(logrus.Fields{
+				"version": detected.String(),
+			}).Info("detected the Kafka version")
+			config.Version = detected
+		}
+	}
 
-	// init consumer
-	client, err := sarama.NewClient(brokersList, config)
+	// init consumer, the checks of the command use its client directly
+	cluster, err := health.NewCluster(brokersList, health.WithConfig(config))
 	if err != nil {
+		exitNagiosUnknown(log, outputs, "Failed to start sarama client", err)
 		log.Fatalf("Failed to start sarama client: %s", err)
 	}
-	defer client.Close()
+	defer cluster.Close()
+	client := cluster.Client()
 
-	// get the list of topics
-	// if none provided, get the list from Kafka
-	if len(topicsList) == 1 && topicsList[0] == "" {
-		topicsList, err = client.Topics()
+	s := &scanner{
+		client:         client,
+		brokers:        brokersList,
+		config:         config,
+		topics:         topicsList,
+		assertions:     assertions,
+		partitions:     partitionFilter,
+		baseline:       baseline,
+		tiers:          tiers,
+		nameConvention: nameConvention,
+		excluded:       excluded,
+		excludedTopics: excludedTopics,
+		redactor:       redactor,
+		knownTopics:    knownTopics,
+		syslog:         syslogW,
+		webhook:        newWebhook(*webhookURL, *webhookRetries, *webhookBackoff, log),
+		limiter:        newLimiter(*rateLimit),
+		log:            log,
+	}
+
+	// dump the snapshot the checks would work on, for debugging
+	if *dumpMeta {
+		state, err := fetchClusterState(client, topicsList)
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"err": err,
-			}).Fatal("Error Listing Topics")
+			}).Fatal("Error Fetching Metadata")
 		}
+		// the ground truth keeps the real names, whatever redactTopics
+		if err := dumpClusterState(os.Stdout, state); err != nil {
+			log.WithFields(logrus.Fields{
+				"err": err,
+			}).Fatal("Error Writing Output")
+		}
+		return
 	}
 
-	// debug the list of topics to check
-	log.WithFields(logrus.Fields{
-		"topics": topicsList,
-		"len":    len(topicsList),
-	}).Debug("topic list generated")
-
-	// parse all topics for replication
-	for _, topic := range topicsList {
-		partitions, err := client.Partitions(topic)
+	// print the racks the checks would work on, for debugging rack awareness
+	if *printRacksF {
+		state, err := fetchClusterState(client, topicsList)
 		if err != nil {
+			log.WithFields(logrus.Fields{
+				"err": err,
+			}).Fatal("Error Fetching Metadata")
+		}
+		if err := printRacks(os.Stdout, state); err != nil {
+			log.WithFields(logrus.Fields{
+				"err": err,
+			}).Fatal("Error Writing Output")
+		}
+		return
+	}
+
+	// inspect the cluster interactively, for debugging
+	if *interactive {
+		runREPL(os.Stdin, os.Stdout, s)
+		return
+	}
+
+	// the scans produce to the canary topic, it can be created for them
+	if *canaryTopic != "" && *canaryCreate {
+		if err := setupCanary(log, client, brokersList, config); err != nil {
 			log.WithFields(logrus.Fields{
 				"err":   err,
-				"topic": topic,
-			}).Fatal("Error Listing Partitions")
+				"topic": *canaryTopic,
+			}).Fatal("Error Creating Canary Topic")
 		}
-		// parse each partition and get replication status
-		for _, partition := range partitions {
+	}
+
+	// SIGINT and SIGTERM interrupt the scan in progress, and stop the
+	// periodic scans and the HTTP server in serve mode
+	ctx := cancelOnSignal(log)
 
-			// find the number of replicas
-			replicas, err := client.Replicas(topic, partition)
+	// in serve mode, scan periodically and serve the results over HTTP
+	if *httpAddr != "" {
+		seed := *jitterSeed
+		if seed == "" {
+			seed, _ = os.Hostname()
+		}
+		offset := jitterOffset(*scanInterval, *pollJitter, seed)
+		log.WithFields(logrus.Fields{
+			"offset":   offset.String(),
+			"interval": scanInterval.String(),
+			"seed":     seed,
+		}).Info("periodic scans offset")
+
+		srv := newServer(ctx, s, log)
+		go srv.run(*scanInterval, offset)
+		err := srv.listenAndServe(*httpAddr)
+		cleanupCanary(log, client, brokersList, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// ndjson streams the failures as they are found, its outputs are opened
+	// before the scan
+	streams := make(map[reportOutput]*ndjsonStream)
+	streamFiles := make(map[reportOutput]io.WriteCloser)
+	for _, o := range outputs {
+		if o.format != formatNDJSON {
+			continue
+		}
+		var w io.Writer = os.Stdout
+		if o.path != "" {
+			f, err := createReportFile(o.path)
 			if err != nil {
 				log.WithFields(logrus.Fields{
-					"topic":     topic,
-					"partition": partition,
-				}).Fatal("Error listing partitions")
+					"err":  err,
+					"file": o.path,
+				}).Fatal("Error Writing Output")
 			}
+			streamFiles[o] = f
+			w = f
+		}
+		streams[o] = newNDJSONStream(w)
+	}
+	if len(streams) > 0 {
+		s.emit = func(f failure) {
+			for _, stream := range streams {
+				stream.failure(f)
+			}
+		}
+	}
 
-			log.WithFields(logrus.Fields{
-				"topic":     topic,
-				"partition": partition,
-				"replica":   replicas,
-			}).Debug("found topic info")
+	// a one-shot scan can be resumed, and record its progress
+	s.resumeFrom = *resumeFrom
+	s.checkpoint = newCheckpoint(*checkpointF, *checkpointI, log)
+	rep, err := s.scan(ctx)
+	cleanupCanary(log, client, brokersList, config)
 
-			// exit with error if replication not OK
-			if *replicaLevel > 0 && len(replicas) != *replicaLevel {
-				log.WithFields(logrus.Fields{
-					"topic":     topic,
-					"partition": partition,
-				}).Fatalf("topics %s:%d is not fully replicated", topic, partition)
+	// the metrics are exported even when the scan failed, to count it
+	st := newStats()
+	st.record(rep, err, *emaAlpha)
+	exportMetrics(log, st)
+	if err != nil {
+		exitNagiosUnknown(log, outputs, "Error Scanning Cluster", err)
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Fatal("Error Scanning Cluster")
+	}
+	logReport(log, rep.truncate(*maxFailures))
+
+	// write the report in each requested format
+	for _, o := range outputs {
+		var err error
+		switch {
+		case streams[o] != nil:
+			err = streams[o].summary(rep)
+			if f := streamFiles[o]; f != nil {
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
 			}
+		case o.path != "":
+			err = writeReportFile(o.path, o.format, rep.truncate(*maxFailures))
+		default:
+			err = writeReport(os.Stdout, o.format, rep.truncate(*maxFailures))
 		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"err":    err,
+				"output": o.format,
+				"file":   o.path,
+			}).Fatal("Error Writing Output")
+		}
+	}
+
+	if out := summaryTableOutput(outputs, log.GetLevel()); *summaryTable && out != nil {
+		printSummaryTable(out, rep.Checked, rep.Failures, terminal.IsTerminal(int(out.Fd())))
 	}
+
+	// exit with error if too many partitions are not OK. Docker only
+	// knows about 1, Nagios has its own codes
+	code := exitCode(rep)
+	if hasNagiosOutput(outputs) {
+		_, code = nagiosStatus(rep)
+	}
+	if code != 0 {
+		if *dockerCheck {
+			code = exitUnhealthy
+		}
+		os.Exit(code)
+	}
+}
+
+// exitCode returns the exit code of the check for the report, 0 if it is
+// healthy. The partitions without a leader come first, as their data is
+// unavailable
+func exitCode(rep *report) int {
+	switch {
+	case len(rep.NoLeader) > 0:
+		return exitNoLeader
+	case rep.TooFewLive:
+		return exitTooFewBroker
+	case !rep.Healthy():
+		return exitUnhealthy
+	}
+	return 0
+}
+
+// failAfter exits with exitUnhealthy if the process is still running after d,
+// whatever it is waiting for
+func failAfter(d time.Duration, log *logrus.Logger) {
+	time.AfterFunc(d, func() {
+		log.WithFields(logrus.Fields{
+			"timeout": d.String(),
+		}).Fatal("Health Check Timed Out")
+	})
 }
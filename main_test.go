@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/namsral/flag"
+)
+
+func TestCountReplicas(t *testing.T) {
+	state := &clusterState{
+		Brokers: map[int32]brokerState{
+			1: {ID: 1},
+			2: {ID: 2},
+		},
+	}
+	// broker 3 is gone, broker 2 is alive but out of sync
+	p := partitionState{ID: 0, Leader: 1, Replicas: []int32{1, 2, 3}, ISR: []int32{1}}
+
+	tests := []struct {
+		mode string
+		want []int32
+	}{
+		{"assigned", []int32{1, 2, 3}},
+		{"isr", []int32{1}},
+		{"live", []int32{1, 2}},
+	}
+	defer func(mode string) { *countMode = mode }(*countMode)
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			if !validCountMode(tt.mode) {
+				t.Fatalf("validCountMode(%q) = false", tt.mode)
+			}
+			*countMode = tt.mode
+			if got := countReplicas(state, p); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("countReplicas() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidCountModeRejectsUnknown(t *testing.T) {
+	for _, mode := range []string{"", "all", "ISR"} {
+		if validCountMode(mode) {
+			t.Errorf("validCountMode(%q) = true, want false", mode)
+		}
+	}
+}
+
+func TestTopicsPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string // TOPICS, unset if empty
+		args []string
+		want []string
+	}{
+		{"env set", "userevent, orders", nil, []string{"userevent", "orders"}},
+		{"flag set", "", []string{"-topics=userevent"}, []string{"userevent"}},
+		{"flag over env", "orders", []string{"-topics=userevent"}, []string{"userevent"}},
+		{"both empty", "", nil, nil},
+		{"env blank", " , ", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// restored at the end of the test
+			t.Setenv("TOPICS", tt.env)
+			if tt.env == "" {
+				os.Unsetenv("TOPICS")
+			}
+			// the flag as defined by main, on its own flag set
+			fs := flag.NewFlagSet("kafka-health", flag.ContinueOnError)
+			topics := fs.String("topics", "", flag.Lookup("topics").Usage)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if got := splitList(*topics); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("topics = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// runMain runs main with args in a child process, as it exits on invalid
+// settings, and returns its output and its exit code
+func runMain(t *testing.T, args ...string) (string, int) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRunMain$")
+	cmd.Env = append(os.Environ(), "KAFKA_HEALTH_MAIN_ARGS="+strings.Join(args, "\n"))
+	out, err := cmd.CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return string(out), exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), 0
+}
+
+// TestRunMain runs main in the child processes of runMain
+func TestRunMain(t *testing.T) {
+	args, ok := os.LookupEnv("KAFKA_HEALTH_MAIN_ARGS")
+	if !ok {
+		t.Skip("only run by runMain")
+	}
+	os.Args = append([]string{"kafka-health"}, strings.Split(args, "\n")...)
+	main()
+}
+
+func TestMainRejectsNegativeReplicaLevel(t *testing.T) {
+	for _, arg := range []string{"-replicaLevel=-1", "-transactionStateReplicaLevel=-2", "-consumerOffsetsReplicaLevel=-3"} {
+		out, code := runMain(t, arg)
+		if code != 1 || !strings.Contains(out, "invalid replicaLevel") {
+			t.Errorf("%s exited with %d, want 1 and an invalid replicaLevel error:\n%s", arg, code, out)
+		}
+	}
+}
+
+func TestMainRejectsOutputInServeMode(t *testing.T) {
+	for _, arg := range []string{"-output=json", "-outputFile=report.json"} {
+		out, code := runMain(t, "-httpAddr=:0", arg)
+		if code != 1 || !strings.Contains(out, "only apply to the one-shot check") {
+			t.Errorf("%s exited with %d, want 1 and an error:\n%s", arg, code, out)
+		}
+	}
+}
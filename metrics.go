@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleMetrics returns the metrics of the scans in the Prometheus text format
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, *name, s.currentStats())
+}
+
+// exportMetrics writes the metrics to the -metricsTextfile and pushes them to
+// the -pushgatewayURL, if set. The errors are logged, they don't fail the
+// check
+func exportMetrics(log *logrus.Logger, st stats) {
+	if *metricsFile != "" {
+		if err := writeMetricsTextfile(*metricsFile, *name, st); err != nil {
+			log.WithFields(logrus.Fields{
+				"err":  err,
+				"file": *metricsFile,
+			}).Error("Error Writing Metrics")
+		}
+	}
+	if *pushgateway != "" {
+		if err := pushMetrics(*pushgateway, *pushJob, *pushInstance, *name, st); err != nil {
+			log.WithFields(logrus.Fields{
+				"err":         err,
+				"pushgateway": *pushgateway,
+			}).Warn("Error Pushing Metrics")
+		}
+	}
+}
+
+// writeMetricsTextfile writes the metrics in the Prometheus text format to
+// path, for the textfile collector of node_exporter. The file is replaced
+// atomically so the collector never reads it partially written
+func writeMetricsTextfile(path, name string, st stats) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	writeMetrics(w, name, st)
+	err = w.Flush()
+	if err == nil {
+		// the temporary file is only readable by its owner
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// writeMetrics writes the metrics in the Prometheus text format. All of them
+// have the name of the health check as label. The gauges give the state of
+// the last successful scan, the counters are cumulated over the lifetime of
+// the process
+func writeMetrics(w io.Writer, name string, st stats) {
+	labels := fmt.Sprintf(`name="%s"`, escapeLabel(name))
+
+	writeMetric(w, "kafka_health_scans_total", "counter", "Number of scans run, successful or not.")
+	fmt.Fprintf(w, "kafka_health_scans_total{%s} %d\n", labels, st.Scans)
+	writeMetric(w, "kafka_health_scan_errors_total", "counter", "Number of scans that couldn't check the cluster.")
+	fmt.Fprintf(w, "kafka_health_scan_errors_total{%s} %d\n", labels, st.ScanErrors)
+
+	writeMetric(w, "kafka_health_controller_changes_total", "counter", "Number of changes of controller seen between two scans.")
+	fmt.Fprintf(w, "kafka_health_controller_changes_total{%s} %d\n", labels, st.Controllers)
+
+	writeMetric(w, "kafka_health_check_duration_seconds", "gauge", "Duration of the last successful check of the cluster.")
+	fmt.Fprintf(w, "kafka_health_check_duration_seconds{%s} %g\n", labels, st.LastDuration)
+	// the name of the duration before kafka_health_check_duration_seconds,
+	// kept for the existing dashboards
+	writeMetric(w, "kafka_health_scan_duration_seconds", "gauge", "Deprecated alias of kafka_health_check_duration_seconds.")
+	fmt.Fprintf(w, "kafka_health_scan_duration_seconds{%s} %g\n", labels, st.LastDuration)
+	writeMetric(w, "kafka_health_scan_duration_ema_seconds", "gauge", "Exponential moving average of the scan durations.")
+	fmt.Fprintf(w, "kafka_health_scan_duration_ema_seconds{%s} %g\n", labels, st.DurationEMA)
+
+	if *canaryTopic != "" {
+		writeMetric(w, "kafka_health_canary_latency_seconds", "gauge", "Round trip of the canary message of the last successful scan.")
+		fmt.Fprintf(w, "kafka_health_canary_latency_seconds{%s} %g\n", labels, st.CanaryLatency)
+	}
+
+	// a partition is either under replicated or offline, never both
+	writeMetric(w, "kafka_health_under_replicated_partitions", "gauge", "Number of partitions without the expected number of replicas in the last successful scan.")
+	fmt.Fprintf(w, "kafka_health_under_replicated_partitions{%s} %d\n", labels, st.Failures[categoryUnderReplicated])
+	writeMetric(w, "kafka_health_offline_partitions", "gauge", "Number of partitions without a leader in the last successful scan.")
+	fmt.Fprintf(w, "kafka_health_offline_partitions{%s} %d\n", labels, st.Failures[categoryOffline])
+
+	writeMetric(w, "kafka_health_topic_unhealthy_partitions", "gauge", "Number of unhealthy partitions of each topic in the last successful scan.")
+	for _, topic := range sortedKeys(st.Unhealthy) {
+		fmt.Fprintf(w, "kafka_health_topic_unhealthy_partitions{%s,topic=\"%s\"} %d\n", labels, escapeLabel(topic), st.Unhealthy[topic])
+	}
+	writeMetric(w, "kafka_health_partition_failure", "gauge", "Failures of the partitions in the last successful scan, 1 by partition and category.")
+	for _, f := range st.failing {
+		fmt.Fprintf(w, "kafka_health_partition_failure{%s,topic=\"%s\",partition=\"%d\",category=\"%s\",severity=\"%s\"} 1\n", labels, escapeLabel(f.Topic), f.Partition, escapeLabel(f.Category), escapeLabel(f.Severity))
+	}
+
+	writeMetric(w, "kafka_health_failures", "gauge", "Number of failures found by the last successful scan, by category.")
+	for _, category := range sortedKeys(st.Failures) {
+		fmt.Fprintf(w, "kafka_health_failures{%s,category=\"%s\"} %d\n", labels, escapeLabel(category), st.Failures[category])
+	}
+	writeMetric(w, "kafka_health_failures_total", "counter", "Number of failures found by all the scans, by category.")
+	for _, category := range sortedKeys(st.FailuresTotal) {
+		fmt.Fprintf(w, "kafka_health_failures_total{%s,category=\"%s\"} %d\n", labels, escapeLabel(category), st.FailuresTotal[category])
+	}
+}
+
+// writeMetric writes the HELP and TYPE lines of a metric
+func writeMetric(w io.Writer, metric, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric, help, metric, typ)
+}
+
+// escapeLabel escapes a label value for the Prometheus text format
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// sortedKeys returns the keys of the map, sorted
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetricsDuration(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, "test", stats{LastDuration: 1.5})
+	for _, want := range []string{
+		`kafka_health_check_duration_seconds{name="test"} 1.5`,
+		`kafka_health_scan_duration_seconds{name="test"} 1.5`,
+		`# HELP kafka_health_scan_duration_seconds Deprecated alias of kafka_health_check_duration_seconds.`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("metrics don't contain %q:\n%s", want, buf.String())
+		}
+	}
+}
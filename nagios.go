@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// exit codes of the Nagios plugins, used instead of the others with
+// -output=nagios
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3 // the cluster couldn't be checked
+)
+
+// nagiosStatus returns the Nagios status of the report and its exit code:
+// CRITICAL when it fails the check, WARNING when it has failures or warnings
+// anyway, else OK
+func nagiosStatus(rep *report) (string, int) {
+	switch {
+	case !rep.Healthy():
+		return "CRITICAL", nagiosCritical
+	case rep.Total > 0 || len(rep.Warnings) > 0:
+		return "WARNING", nagiosWarning
+	}
+	return "OK", nagiosOK
+}
+
+// writeNagios writes the single line output of a Nagios plugin: the status
+// and the summary of the report, then its performance data after a |
+func writeNagios(w io.Writer, rep *report) error {
+	status, _ := nagiosStatus(rep)
+	perf := []string{
+		fmt.Sprintf("unhealthy=%d;;;0;%d", rep.Unhealthy, rep.Checked),
+		fmt.Sprintf("checked=%d;;;0", rep.Checked),
+		fmt.Sprintf("failures=%d;;;0", rep.Total),
+		fmt.Sprintf("percent=%.2f%%;;;0;100", rep.Percent),
+		fmt.Sprintf("brokers=%d;;;0", len(rep.LiveBrokers)),
+		fmt.Sprintf("duration=%.3fs;;;0", rep.Duration),
+	}
+	if rep.Canary != nil {
+		perf = append(perf, fmt.Sprintf("canary=%.3fs;;%g;0", rep.Canary.Latency, rep.Canary.Deadline))
+	}
+	_, err := fmt.Fprintf(w, "KAFKA-HEALTH %s - [%s] %d of %d partitions are not healthy (%.2f%%) | %s\n",
+		status, rep.Name, rep.Unhealthy, rep.Checked, rep.Percent, strings.Join(perf, " "))
+	return err
+}
+
+// writeNagiosError writes the single line output of a Nagios plugin that
+// couldn't check the cluster
+func writeNagiosError(w io.Writer, err error) error {
+	_, werr := fmt.Fprintf(w, "KAFKA-HEALTH UNKNOWN - [%s] error checking the cluster: %s\n", *name, strings.Replace(err.Error(), "|", "/", -1))
+	return werr
+}
+
+// hasNagiosOutput returns true if the report is written with -output=nagios
+func hasNagiosOutput(outputs []reportOutput) bool {
+	for _, o := range outputs {
+		if o.format == formatNagios {
+			return true
+		}
+	}
+	return false
+}
+
+// exitNagiosUnknown writes the error of a check that couldn't complete to the
+// nagios outputs, and exits with nagiosUnknown. It does nothing without a
+// nagios output, the caller handles the error
+func exitNagiosUnknown(log *logrus.Logger, outputs []reportOutput, msg string, err error) {
+	if !hasNagiosOutput(outputs) {
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"err": err,
+	}).Error(msg)
+	for _, o := range outputs {
+		if o.format != formatNagios {
+			continue
+		}
+		if o.path == "" {
+			writeNagiosError(os.Stdout, err)
+			continue
+		}
+		if f, ferr := createReportFile(o.path); ferr == nil {
+			writeNagiosError(f, err)
+			f.Close()
+		}
+	}
+	os.Exit(nagiosUnknown)
+}
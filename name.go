@@ -0,0 +1,18 @@
+package main
+
+import "github.com/sirupsen/logrus"
+
+// nameHook adds the name of the health check to all the log entries, to tell
+// apart the logs of several deployments
+type nameHook struct {
+	name string
+}
+
+func (nameHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h nameHook) Fire(e *logrus.Entry) error {
+	setField(e, "name", h.name)
+	return nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// output formats of the report
+const (
+	formatJSON     = "json"
+	formatText     = "text"
+	formatCSV      = "csv"
+	formatNDJSON   = "ndjson"
+	formatGraphite = "graphite"
+	formatNagios   = "nagios"
+)
+
+// validOutputFormat returns true if format is a supported output format
+func validOutputFormat(format string) bool {
+	switch format {
+	case formatJSON, formatText, formatCSV, formatNDJSON, formatGraphite, formatNagios:
+		return true
+	}
+	return false
+}
+
+// outputFormat returns the format to write the report to path with. The
+// explicit format wins, else it is inferred from the extension of path,
+// ignoring the .gz one
+func outputFormat(path, format string) (string, error) {
+	if format != "" {
+		return format, nil
+	}
+	if gzipped(path) {
+		path = path[:len(path)-len(".gz")]
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return formatJSON, nil
+	case ".txt":
+		return formatText, nil
+	case ".csv":
+		return formatCSV, nil
+	case ".ndjson":
+		return formatNDJSON, nil
+	}
+	return "", fmt.Errorf("can't infer the output format of %s, use -output", path)
+}
+
+// reportOutput is a format of the report and where to write it
+type reportOutput struct {
+	format string
+	path   string // file to write the report to, stdout if empty
+}
+
+// parseOutputs parses the -output list of format or format:path, and the
+// -outputFile, the destination of a single format. The formats without a path
+// are written to stdout, at most one of them can be, so they don't mix
+func parseOutputs(list, file string) ([]reportOutput, error) {
+	entries := splitList(list)
+	if file != "" {
+		if len(entries) > 1 {
+			return nil, fmt.Errorf("outputFile takes a single format, pair each format with its file as format:path instead")
+		}
+		var format string
+		if len(entries) == 1 {
+			format = entries[0]
+		}
+		if strings.Contains(format, ":") {
+			return nil, fmt.Errorf("output %q already has a file, it can't be combined with outputFile", format)
+		}
+		format, err := outputFormat(file, format)
+		if err != nil {
+			return nil, err
+		}
+		entries = []string{format + ":" + file}
+	}
+
+	var outputs []reportOutput
+	var stdout string
+	paths := make(map[string]bool)
+	for _, entry := range entries {
+		o := reportOutput{format: entry}
+		if i := strings.Index(entry, ":"); i >= 0 {
+			o.format, o.path = entry[:i], entry[i+1:]
+			if o.path == "" {
+				return nil, fmt.Errorf("missing file in output %q", entry)
+			}
+		}
+		if !validOutputFormat(o.format) {
+			return nil, fmt.Errorf("invalid output %q, must be one of json, text, csv, ndjson, graphite or nagios", o.format)
+		}
+		switch {
+		case o.path == "" && stdout != "":
+			return nil, fmt.Errorf("outputs %s and %s would both be written to stdout, give one of them a file as format:path", stdout, o.format)
+		case o.path == "":
+			stdout = o.format
+		case paths[o.path]:
+			return nil, fmt.Errorf("several outputs are written to %s", o.path)
+		}
+		paths[o.path] = true
+		outputs = append(outputs, o)
+	}
+	return outputs, nil
+}
+
+// gzipped returns true if the file at path is to be gzip compressed
+func gzipped(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".gz"
+}
+
+// writeReportFile writes the report to path in the given format, creating the
+// parent directories if needed. The file is gzip compressed if path ends in .gz
+func writeReportFile(path, format string, rep *report) error {
+	f, err := createReportFile(path)
+	if err != nil {
+		return err
+	}
+	if err := writeReport(f, format, rep); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// createReportFile creates the file at path, and its parent directories if
+// needed. The file is gzip compressed if path ends in .gz
+func createReportFile(path string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !gzipped(path) {
+		return f, nil
+	}
+	return &gzipFile{Writer: gzip.NewWriter(f), f: f}, nil
+}
+
+// gzipFile is a gzip compressed file
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+// Close flushes the whole gzip stream, then closes the file
+func (g *gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.f.Close()
+		return err
+	}
+	return g.f.Close()
+}
+
+// writeReport writes the report in the given format
+func writeReport(w io.Writer, format string, rep *report) error {
+	switch format {
+	case formatJSON:
+		return json.NewEncoder(w).Encode(rep)
+	case formatText:
+		return writeText(w, rep)
+	case formatCSV:
+		return writeCSV(w, rep)
+	case formatNDJSON:
+		stream := newNDJSONStream(w)
+		for _, f := range rep.Failures {
+			stream.failure(f)
+		}
+		return stream.summary(rep)
+	case formatGraphite:
+		return writeGraphite(w, *graphitePrefix, rep)
+	case formatNagios:
+		return writeNagios(w, rep)
+	}
+	return fmt.Errorf("unknown output format %s", format)
+}
+
+// reportStatus returns the overall severity of the report: CRITICAL if it
+// fails the check, WARN if it has failures anyway, else OK
+func reportStatus(rep *report) string {
+	if !rep.Healthy() {
+		return severityCritical
+	} else if rep.Total > 0 {
+		return severityWarn
+	}
+	return severityOK
+}
+
+// summaryLine returns the one line summary of the report, with its status
+func summaryLine(rep *report) string {
+	return fmt.Sprintf("%s [%s]: %d of %d partitions are not healthy (%.2f%%)", reportStatus(rep), rep.Name, rep.Unhealthy, rep.Checked, rep.Percent)
+}
+
+// writeText writes a human readable summary of the report: its status, each
+// failure, and the summary table with -summaryTable
+func writeText(w io.Writer, rep *report) error {
+	fmt.Fprintln(w, summaryLine(rep))
+	if len(rep.NoLeader) > 0 {
+		fmt.Fprintf(w, "%s: %d partitions have no leader, their data is unavailable: %s\n", severityCritical, len(rep.NoLeader), strings.Join(rep.NoLeader, ", "))
+	}
+	if rep.TooFewLive {
+		fmt.Fprintf(w, "%s: only %d brokers are live %v, expected at least %d\n", severityCritical, len(rep.LiveBrokers), rep.LiveBrokers, rep.MinBrokers)
+	}
+	for _, p := range rep.Probes {
+		if p.Reachable {
+			fmt.Fprintf(w, "%s: broker %d at %s reached in %.3fs\n", severityOK, p.ID, p.Addr, p.Latency)
+		}
+	}
+	if c := rep.Canary; c != nil {
+		if c.Failed {
+			fmt.Fprintf(w, "%s: canary message through topic %s failed after %.3fs: %s\n", severityCritical, c.Topic, c.Latency, c.Err)
+		} else {
+			fmt.Fprintf(w, "%s: canary message through topic %s made the round trip in %.3fs\n", severityOK, c.Topic, c.Latency)
+		}
+	}
+	for _, c := range rep.Components {
+		switch {
+		case !c.Exists:
+			fmt.Fprintf(w, "%s: %s topic %s does not exist yet\n", severityOK, c.Name, c.Topic)
+		case c.miscounted():
+			fmt.Fprintf(w, "%s: %s topic %s has %d partitions instead of %d\n", severityWarn, c.Name, c.Topic, c.Count, c.Wanted)
+		case !c.Healthy():
+			fmt.Fprintf(w, "%s: %s topic %s has %d of %d partitions not healthy\n", severityWarn, c.Name, c.Topic, c.Unhealthy, c.Partitions)
+		default:
+			fmt.Fprintf(w, "%s: %s topic %s is healthy\n", severityOK, c.Name, c.Topic)
+		}
+	}
+	for _, t := range rep.Tiers {
+		status := severityOK
+		if !t.Passed {
+			status = severityWarn
+		}
+		fmt.Fprintf(w, "%s: tier %s (%d replicas) has %d of %d partitions not healthy, in %d topics\n", status, t.Name, t.ReplicaLevel, t.Unhealthy, t.Partitions, t.Topics)
+	}
+	if l := rep.Leaders; l != nil && len(l.NonPreferred) > 0 {
+		status := severityWarn
+		if l.Failed {
+			status = severityCritical
+		}
+		fmt.Fprintf(w, "%s: %d partitions are not led by their preferred replica (%.2f%%): %s\n", status, len(l.NonPreferred), l.Percent, strings.Join(l.NonPreferred, ", "))
+	}
+	for _, l := range rep.LeaderBalance {
+		status := severityOK
+		if l.Failed {
+			status = severityCritical
+		}
+		fmt.Fprintf(w, "%s: the brokers lead up to %d partitions of topic %s for a mean of %.2f, ratio %.2f (max %.2f): %v\n", status, l.Max, l.Topic, l.Mean, l.Ratio, l.MaxRatio, l.Leaders)
+	}
+	for _, l := range rep.Preferred {
+		if l.Failed {
+			fmt.Fprintf(w, "%s: up to %d partitions of topic %s prefer the same broker as leader, for %d in an even spread, ratio %.2f (max %.2f): %v\n", severityCritical, l.Max, l.Topic, l.Ideal, l.Ratio, l.MaxRatio, l.Leaders)
+		}
+	}
+	if c := rep.Controller; c != nil {
+		status := severityOK
+		if c.Failed {
+			status = severityCritical
+		}
+		fmt.Fprintf(w, "%s: the controller, now broker %d, changed %d times within %.0fs (max %d)\n", status, c.ID, c.Changes, c.Window, c.Max)
+	}
+	if b := rep.Balance; b != nil {
+		status := severityOK
+		if b.Failed {
+			status = severityCritical
+		}
+		fmt.Fprintf(w, "%s: the brokers host up to %d replicas for a mean of %.2f, ratio %.2f (max %.2f)\n", status, b.Max, b.Mean, b.Ratio, b.MaxRatio)
+	}
+	if len(rep.Excluded) > 0 {
+		fmt.Fprintf(w, "brokers %v are excluded from the checks\n", rep.Excluded)
+	}
+	if len(rep.Recovered) > 0 {
+		fmt.Fprintf(w, "%s: %d partitions recovered within the grace period: %s\n", severityOK, len(rep.Recovered), strings.Join(rep.Recovered, ", "))
+	}
+	for _, msg := range rep.Warnings {
+		fmt.Fprintf(w, "%s: %s\n", severityWarn, msg)
+	}
+	if d := rep.TopicDrift; d != nil {
+		for _, topic := range d.Added {
+			fmt.Fprintf(w, "%s: topic %s appeared\n", severityCritical, topic)
+		}
+		for _, topic := range d.Removed {
+			fmt.Fprintf(w, "%s: topic %s disappeared\n", severityCritical, topic)
+		}
+	}
+	for _, topic := range rep.BadNames {
+		fmt.Fprintf(w, "%s: topic %s doesn't match the naming convention\n", severityWarn, topic)
+	}
+	if a := rep.SingleReplica; a != nil {
+		severity := severityWarn
+		if a.Failed {
+			severity = severityCritical
+		} else if a.Partitions == 0 {
+			severity = severityOK
+		}
+		fmt.Fprintf(w, "%s: %d partitions of %d topics have a single replica\n", severity, a.Partitions, len(a.Topics))
+		for _, topic := range a.topicNames() {
+			fmt.Fprintf(w, "%s: topic %s has a single replica on partitions %v\n", severity, topic, a.Topics[topic])
+		}
+	}
+	for _, topic := range rep.Deleting {
+		fmt.Fprintf(w, "%s: topic %s is being deleted\n", severityWarn, topic)
+	}
+	for _, f := range rep.Failures {
+		fmt.Fprintf(w, "%s: %s\n", f.Severity, f)
+	}
+	if rep.Truncated {
+		fmt.Fprintf(w, "... %d more failures\n", rep.Total-len(rep.Failures))
+	}
+	for _, a := range rep.MissingACLs {
+		fmt.Fprintf(w, "%s: ACL %s is missing\n", severityCritical, a)
+	}
+	if d := rep.Baseline; d != nil {
+		fmt.Fprintf(w, "\ncompared to the baseline (policy %s): %d added, %d removed, %d changed\n", d.Policy, len(d.Added), len(d.Removed), len(d.Changed))
+		for _, f := range d.Added {
+			fmt.Fprintf(w, "+ %s\n", f)
+		}
+		for _, f := range d.Removed {
+			fmt.Fprintf(w, "- %s\n", f)
+		}
+		for _, f := range d.Changed {
+			fmt.Fprintf(w, "~ %s, replicas %v\n", f, f.Replicas)
+		}
+	}
+	if *summaryTable {
+		fmt.Fprintln(w)
+		printSummaryTable(w, rep.Checked, rep.Failures, false)
+	}
+	return nil
+}
+
+// writeCSV writes a header and a row per failure. Nothing is written for a
+// run without failures, unless csvIncludeHealthy is set
+func writeCSV(w io.Writer, rep *report) error {
+	if len(rep.Failures) == 0 && !*csvHealthy {
+		return nil
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "cluster", "topic", "partition", "category", "expectedReplicas", "actualReplicas", "inSyncReplicas", "severity"})
+	for _, f := range rep.Failures {
+		cw.Write([]string{
+			rep.Time.UTC().Format(time.RFC3339),
+			rep.Cluster,
+			f.Topic,
+			strconv.Itoa(int(f.Partition)),
+			f.Category,
+			strconv.Itoa(f.Expected),
+			strconv.Itoa(len(f.Replicas)),
+			strconv.Itoa(len(f.ISR)),
+			f.Severity,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ndjsonStream writes the failures as newline delimited JSON objects as soon
+// as they are found, then the summary of the report. Writes are serialized
+type ndjsonStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error // first error writing, the next writes are skipped
+}
+
+// ndjsonFailure is a failure in the stream
+type ndjsonFailure struct {
+	Type string `json:"type"` // always failure
+	failure
+}
+
+// ndjsonSummary is the summary of the report closing the stream
+type ndjsonSummary struct {
+	Type string `json:"type"` // always summary
+	*report
+}
+
+func newNDJSONStream(w io.Writer) *ndjsonStream {
+	return &ndjsonStream{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// failure writes a failure. Its severity is only known once the scan is
+// complete, so it is left empty
+func (s *ndjsonStream) failure(f failure) {
+	s.write(ndjsonFailure{Type: "failure", failure: f})
+}
+
+// summary writes the report, without the failures already written, and
+// returns the first error that happened writing the stream
+func (s *ndjsonStream) summary(rep *report) error {
+	r := *rep
+	r.Failures = nil
+	s.write(ndjsonSummary{Type: "summary", report: &r})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *ndjsonStream) write(v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = s.enc.Encode(v)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		file    string
+		want    []reportOutput
+		wantErr bool
+	}{
+		{name: "none", want: nil},
+		{name: "stdout", list: "json", want: []reportOutput{{format: formatJSON}}},
+		{name: "stdout and file", list: "nagios,json:report.json", want: []reportOutput{{format: formatNagios}, {format: formatJSON, path: "report.json"}}},
+		{name: "two on stdout", list: "nagios,json", wantErr: true},
+		{name: "same file", list: "json:report,csv:report", wantErr: true},
+		{name: "missing file", list: "json:", wantErr: true},
+		{name: "invalid format", list: "yaml", wantErr: true},
+		{name: "inferred from the file", file: "out/report.csv.gz", want: []reportOutput{{format: formatCSV, path: "out/report.csv.gz"}}},
+		{name: "format of the file", list: "text", file: "report.json", want: []reportOutput{{format: formatText, path: "report.json"}}},
+		{name: "unknown extension", file: "report.log", wantErr: true},
+		{name: "several formats for the file", list: "json,csv", file: "report.json", wantErr: true},
+		{name: "format with a file and the file", list: "json:a.json", file: "b.json", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOutputs(tt.list, tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOutputs(%q, %q) error = %v, want error %v", tt.list, tt.file, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOutputs(%q, %q) = %+v, want %+v", tt.list, tt.file, got, tt.want)
+			}
+		})
+	}
+}
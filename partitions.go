@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsePartitionFilter parses a semicolon separated list of topics with the
+// comma separated list of the partitions to check for each of them, ex:
+// orders:0,1,5;payments:2
+func parsePartitionFilter(s string) (map[string][]int32, error) {
+	filter := make(map[string][]int32)
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid partition filter %q, expected topic:partition,partition...", item)
+		}
+		for _, p := range strings.Split(parts[1], ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(p), 10, 32)
+			if err != nil || id < 0 {
+				return nil, fmt.Errorf("invalid partition %q in %q", p, item)
+			}
+			filter[parts[0]] = append(filter[parts[0]], int32(id))
+		}
+	}
+	return filter, nil
+}
+
+// containsPartition returns true if the partition id is in the list
+func containsPartition(partitions []int32, id int32) bool {
+	for _, p := range partitions {
+		if p == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/namsral/flag"
+)
+
+// modes of -printConfig
+const (
+	printConfigContinue = "continue" // print the config, then run as usual
+	printConfigExit     = "exit"     // print the config and exit
+)
+
+// secretFlagWords are the words that mark a flag as holding a secret, its value
+// is never printed
+var secretFlagWords = []string{"password", "secret", "token", "keypem"}
+
+// isSecretFlag returns true if the value of the flag must be redacted
+func isSecretFlag(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range secretFlagWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// printConfig writes the resolved value of every flag, whether it comes from
+// the command line, the environment, a config file or its default, as a
+// command line that runs kafka-health with the exact same settings. Flags are
+// sorted by name, one per line, and secrets are redacted
+func printConfig(w io.Writer) {
+	fmt.Fprint(w, "kafka-health")
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "printConfig" || f.Name == flag.DefaultConfigFlagname {
+			return
+		}
+		value := f.Value.String()
+		if isSecretFlag(f.Name) && value != "" {
+			value = "REDACTED"
+		}
+		fmt.Fprintf(w, " \\\n  -%s=%s", f.Name, shellQuote(value))
+	})
+	fmt.Fprintln(w)
+}
+
+// shellQuote quotes s for a POSIX shell, unless it only holds characters that
+// don't need it
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.,:/@=+") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
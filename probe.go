@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// brokerProbe is the result of a connection to a broker, from the vantage
+// point of the health check
+type brokerProbe struct {
+	ID        int32   `json:"id"`
+	Addr      string  `json:"addr"`
+	Reachable bool    `json:"reachable"`
+	Latency   float64 `json:"latencySeconds"` // time to connect, including the TLS and SASL handshakes
+	Err       string  `json:"err,omitempty"`  // why the broker can't be reached
+}
+
+// probeBrokers opens a new connection to each live broker of the snapshot, all
+// at once, and closes it right away. The connections of the client are left
+// alone, so a broker it is already connected to is still probed. The results
+// are sorted by broker ID
+func probeBrokers(state *clusterState, config *sarama.Config) []brokerProbe {
+	probes := make([]brokerProbe, 0, len(state.Brokers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, b := range state.Brokers {
+		wg.Add(1)
+		go func(b brokerState) {
+			defer wg.Done()
+			p := probeBroker(b, config)
+			mu.Lock()
+			probes = append(probes, p)
+			mu.Unlock()
+		}(b)
+	}
+	wg.Wait()
+	sort.Slice(probes, func(i, j int) bool { return probes[i].ID < probes[j].ID })
+	return probes
+}
+
+// probeBroker connects to a broker and closes the connection
+func probeBroker(b brokerState, config *sarama.Config) brokerProbe {
+	p := brokerProbe{ID: b.ID, Addr: b.Addr}
+	start := time.Now()
+	conn := sarama.NewBroker(b.Addr)
+	err := conn.Open(config)
+	if err == nil {
+		// Open connects in the background, Connected waits for it
+		_, err = conn.Connected()
+	}
+	p.Latency = time.Since(start).Seconds()
+	if err != nil {
+		p.Err = err.Error()
+		return p
+	}
+	conn.Close()
+	p.Reachable = true
+	return p
+}
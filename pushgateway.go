@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pushTimeout bounds a push of the metrics to the Pushgateway
+const pushTimeout = 10 * time.Second
+
+// pushMetrics pushes the metrics in the Prometheus text format to the
+// Pushgateway at gateway, in the group of job and instance. The whole group
+// is replaced, so the metrics of a previous run don't linger
+func pushMetrics(gateway, job, instance, name string, st stats) error {
+	var body bytes.Buffer
+	writeMetrics(&body, name, st)
+	req, err := http.NewRequest(http.MethodPut, pushURL(gateway, job, instance), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: pushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// pushURL returns the URL of the group of job and instance on the Pushgateway
+func pushURL(gateway, job, instance string) string {
+	return strings.TrimSuffix(gateway, "/") + "/metrics/job" + pushLabel(job) + "/instance" + pushLabel(instance)
+}
+
+// pushLabel returns the path element of a grouping label value. The values
+// that can't be part of a path, empty or with a /, are base64 encoded, as the
+// Pushgateway allows
+func pushLabel(v string) string {
+	switch {
+	case v == "":
+		return "@base64/="
+	case strings.Contains(v, "/"):
+		return "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(v))
+	}
+	return "/" + url.PathEscape(v)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter throttles the requests sent to the brokers to a given rate. It is a
+// token bucket holding a single token, so requests are evenly spaced instead
+// of sent in bursts. A nil limiter does nothing
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration // minimum interval between two requests
+	next     time.Time     // when the next request is allowed
+}
+
+// newLimiter returns a limiter allowing rate requests per second, or nil if
+// rate is 0 or less
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{
+		interval: time.Duration(float64(time.Second) / rate),
+	}
+}
+
+// wait blocks until the next request is allowed, or ctx is done. It returns
+// the error of ctx if it is done first
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
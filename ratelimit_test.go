@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	l := newLimiter(1)
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatalf("first wait returned %v", err)
+	}
+
+	// the next request is allowed in 1s, the wait is given up before
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if took := time.Since(start); took >= time.Second {
+		t.Errorf("canceled wait took %s, the whole interval", took)
+	}
+
+	var none *limiter
+	if err := none.wait(ctx); err != nil {
+		t.Errorf("nil limiter returned %v", err)
+	}
+}
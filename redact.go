@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// redactedPrefix starts the masked topic names
+const redactedPrefix = "redacted-"
+
+// topicToken matches the words of a text that can be topic names, Kafka only
+// allows these characters in them
+var topicToken = regexp.MustCompile(`[A-Za-z0-9._-]+`)
+
+// redactedName matches a topic name already masked
+var redactedName = regexp.MustCompile(`^` + redactedPrefix + `[0-9a-f]{12}$`)
+
+// topicRedactor masks the sensitive topic names in the outputs, replacing
+// them with a hash of the name. The hash is stable, so a topic gets the same
+// masked name in every output and across runs. A nil topicRedactor masks
+// nothing
+type topicRedactor struct {
+	re *regexp.Regexp // sensitive topic names, matching the whole name
+
+	mu    sync.Mutex
+	known map[string]bool // topics of the cluster, masked when found in a text
+}
+
+// newTopicRedactor returns a redactor masking the topic names matching
+// pattern, or nil if pattern is empty
+func newTopicRedactor(pattern string) (*topicRedactor, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &topicRedactor{re: re, known: make(map[string]bool)}, nil
+}
+
+// learn records the topics of the snapshot, so they are masked when found in
+// a text. Only the topics are, not the other words matching the pattern
+func (r *topicRedactor) learn(state *clusterState) {
+	if r == nil {
+		return
+	}
+	names := make([]string, 0, len(state.Topics))
+	for name := range state.Topics {
+		names = append(names, name)
+	}
+	r.learnNames(names...)
+}
+
+// learnNames records topic names known before the first snapshot, like the
+// ones given on the command line, so they are masked in the logs written
+// before it
+func (r *topicRedactor) learnNames(names ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		r.known[name] = true
+	}
+}
+
+// topic returns the masked name of a sensitive topic, the name itself for
+// the others
+func (r *topicRedactor) topic(name string) string {
+	if r == nil || redactedName.MatchString(name) || !r.re.MatchString(name) {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return redactedPrefix + hex.EncodeToString(sum[:])[:12]
+}
+
+// text masks the sensitive topic names found in a text, like a log message or
+// a topic:partition
+func (r *topicRedactor) text(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return topicToken.ReplaceAllStringFunc(s, func(word string) string {
+		if !r.known[word] {
+			return word
+		}
+		return r.topic(word)
+	})
+}
+
+func (r *topicRedactor) texts(l []string) []string {
+	if r == nil || l == nil {
+		return l
+	}
+	masked := make([]string, len(l))
+	for i, s := range l {
+		masked[i] = r.text(s)
+	}
+	return masked
+}
+
+// error masks the sensitive topic names in the message of an error
+func (r *topicRedactor) error(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	return errors.New(r.text(err.Error()))
+}
+
+func (r *topicRedactor) failure(f failure) failure {
+	if r != nil {
+		f.Topic = r.topic(f.Topic)
+	}
+	return f
+}
+
+func (r *topicRedactor) failures(fs []failure) []failure {
+	if r == nil || fs == nil {
+		return fs
+	}
+	masked := make([]failure, len(fs))
+	for i, f := range fs {
+		masked[i] = r.failure(f)
+	}
+	return masked
+}
+
+// report returns a copy of the report with the sensitive topic names masked
+// everywhere, including the metadata snapshot served by the status pages
+func (r *topicRedactor) report(rep *report) *report {
+	if r == nil {
+		return rep
+	}
+	c := *rep
+	c.Failures = r.failures(rep.Failures)
+	c.all = r.failures(rep.all)
+	c.NoLeader = r.texts(rep.NoLeader)
+	c.Escalated = r.texts(rep.Escalated)
+	c.Recovered = r.texts(rep.Recovered)
+	c.Warnings = r.texts(rep.Warnings)
+	c.TimedOut = r.texts(rep.TimedOut)
+	c.Skipped = r.texts(rep.Skipped)
+	c.Deleting = r.texts(rep.Deleting)
+	c.BadNames = r.texts(rep.BadNames)
+	if rep.Levels != nil {
+		c.Levels = make(map[string]int, len(rep.Levels))
+		for topic, level := range rep.Levels {
+			c.Levels[r.topic(topic)] = level
+		}
+	}
+	if rep.SingleReplica != nil {
+		a := *rep.SingleReplica
+		a.Topics = make(map[string][]int32, len(rep.SingleReplica.Topics))
+		for topic, ids := range rep.SingleReplica.Topics {
+			a.Topics[r.topic(topic)] = ids
+		}
+		c.SingleReplica = &a
+	}
+	if rep.Canary != nil {
+		cn := *rep.Canary
+		cn.Topic = r.topic(cn.Topic)
+		cn.Err = r.text(cn.Err)
+		c.Canary = &cn
+	}
+	if rep.TopicDrift != nil {
+		c.TopicDrift = &topicDrift{Added: r.texts(rep.TopicDrift.Added), Removed: r.texts(rep.TopicDrift.Removed)}
+	}
+	c.Components = nil
+	for _, comp := range rep.Components {
+		masked := *comp
+		masked.Topic = r.topic(comp.Topic)
+		c.Components = append(c.Components, &masked)
+	}
+	if rep.Leaders != nil {
+		l := *rep.Leaders
+		l.NonPreferred = r.texts(l.NonPreferred)
+		c.Leaders = &l
+	}
+	c.LeaderBalance = nil
+	for _, l := range rep.LeaderBalance {
+		l.Topic = r.topic(l.Topic)
+		c.LeaderBalance = append(c.LeaderBalance, l)
+	}
+	c.Preferred = nil
+	for _, l := range rep.Preferred {
+		l.Topic = r.topic(l.Topic)
+		c.Preferred = append(c.Preferred, l)
+	}
+	if rep.Baseline != nil {
+		b := *rep.Baseline
+		b.Added = r.failures(b.Added)
+		b.Removed = r.failures(b.Removed)
+		b.Changed = r.failures(b.Changed)
+		c.Baseline = &b
+	}
+	if rep.Broker != nil {
+		b := *rep.Broker
+		b.OutOfSync = r.texts(b.OutOfSync)
+		c.Broker = &b
+	}
+	if rep.state != nil {
+		c.state = r.clusterState(rep.state)
+	}
+	return &c
+}
+
+// clusterState returns a copy of the snapshot with the sensitive topic names
+// masked
+func (r *topicRedactor) clusterState(state *clusterState) *clusterState {
+	if r == nil {
+		return state
+	}
+	c := *state
+	c.Topics = make(map[string]*topicState, len(state.Topics))
+	for name, ts := range state.Topics {
+		masked := *ts
+		masked.Name = r.topic(name)
+		c.Topics[masked.Name] = &masked
+	}
+	return &c
+}
+
+// redactable is a value logged as a field holding topic names, masked by
+// redactHook. The fields of the other types are logged as is
+type redactable interface {
+	redact(r *topicRedactor) interface{}
+}
+
+// redactHook masks the sensitive topic names in the message and the fields
+// of the logs
+type redactHook struct {
+	redactor *topicRedactor
+}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h redactHook) Fire(e *logrus.Entry) error {
+	e.Message = h.redactor.text(e.Message)
+	data := make(logrus.Fields, len(e.Data))
+	for k, v := range e.Data {
+		switch v := v.(type) {
+		case string:
+			data[k] = h.redactor.text(v)
+		case []string:
+			data[k] = h.redactor.texts(v)
+		case error:
+			data[k] = h.redactor.text(v.Error())
+		case redactable:
+			data[k] = v.redact(h.redactor)
+		default:
+			data[k] = v
+		}
+	}
+	e.Data = data
+	return nil
+}
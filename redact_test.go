@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRedactLearnNames(t *testing.T) {
+	r, err := newTopicRedactor("tenant-.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked := r.topic("tenant-a")
+	msg := "error listing partitions of topic tenant-a"
+	if got := r.text(msg); got != msg {
+		t.Fatalf("text(%q) = %q, masked an unknown topic", msg, got)
+	}
+
+	// as main does with -topics, before the first snapshot
+	r.learnNames("tenant-a", "orders")
+	if got, want := r.text(msg), "error listing partitions of topic "+masked; got != want {
+		t.Errorf("text(%q) = %q, want %q", msg, got, want)
+	}
+	if got := r.text("topic orders"); got != "topic orders" {
+		t.Errorf("text() = %q, masked a topic not matching the pattern", got)
+	}
+
+	// the hook masks the messages of the logs
+	rec := &entryRecorder{}
+	log := newTestLogger(redactHook{redactor: r}, rec)
+	log.Error(msg)
+	if _, ok := rec.messages(logrus.ErrorLevel)["error listing partitions of topic "+masked]; !ok {
+		t.Errorf("log not masked: %v", rec.messages(logrus.ErrorLevel))
+	}
+}
+
+func TestRedactNil(t *testing.T) {
+	var r *topicRedactor
+	r.learnNames("tenant-a")
+	if got := r.text("topic tenant-a"); got != "topic tenant-a" {
+		t.Errorf("nil redactor masked %q", got)
+	}
+}
+
+func TestRedactSlowestTopics(t *testing.T) {
+	r, err := newTopicRedactor("tenant-.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.learnNames("tenant-a", "orders")
+	rec := &entryRecorder{}
+	log := newTestLogger(redactHook{redactor: r}, rec)
+
+	// as a scan does with -redactTopics and -timeTopics
+	logSlowestTopics(log.WithField("scanID", "test"), []topicDuration{
+		{Topic: "orders", Duration: time.Second},
+		{Topic: "tenant-a", Duration: 2 * time.Second},
+	}, 0)
+	e, ok := rec.messages(logrus.InfoLevel)["2 slowest topics"]
+	if !ok {
+		t.Fatalf("slowest topics not logged: %v", rec.messages(logrus.InfoLevel))
+	}
+	want := slowTopics{
+		{Topic: r.topic("tenant-a"), Duration: 2},
+		{Topic: "orders", Duration: 1},
+	}
+	if got := e.Data["slowestTopics"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("slowestTopics = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const replHelp = `commands:
+  topics                          list the topics and their number of partitions
+  partitions <topic>              show the leader, replicas and ISR of each partition of a topic
+  replicas <topic> <partition>    show the leader, replicas, ISR and offline replicas of a partition
+  check <topic>                   run the checks on a topic
+  help                            show this help
+  quit                            exit
+`
+
+// runREPL reads commands from in, one per line, and writes their output to
+// out, until quit or the end of in. The commands reuse the client of the
+// scanner, and fetch fresh metadata each time
+func runREPL(in io.Reader, out io.Writer, s *scanner) {
+	fmt.Fprint(out, replHelp)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "exit" {
+			return
+		}
+		if err := replCommand(out, s, args); err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+		}
+	}
+}
+
+// replCommand runs a command of the REPL
+func replCommand(out io.Writer, s *scanner, args []string) error {
+	switch args[0] {
+	case "help":
+		fmt.Fprint(out, replHelp)
+		return nil
+	case "topics":
+		state, err := fetchClusterState(s.client, nil)
+		if err != nil {
+			return err
+		}
+		for _, name := range state.TopicNames() {
+			fmt.Fprintf(out, "%s\t%d partitions\n", name, len(state.Topics[name].Partitions))
+		}
+		return nil
+	case "partitions":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: partitions <topic>")
+		}
+		ts, err := replTopic(s, args[1])
+		if err != nil {
+			return err
+		}
+		for _, p := range ts.Partitions {
+			fmt.Fprintf(out, "%s\tleader %d\treplicas %v\tisr %v\n", partitionName(ts.Name, p.ID), p.Leader, p.Replicas, p.ISR)
+		}
+		return nil
+	case "replicas":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: replicas <topic> <partition>")
+		}
+		id, err := strconv.ParseInt(args[2], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid partition %q", args[2])
+		}
+		ts, err := replTopic(s, args[1])
+		if err != nil {
+			return err
+		}
+		for _, p := range ts.Partitions {
+			if p.ID == int32(id) {
+				fmt.Fprintf(out, "leader   %d\nreplicas %v\nisr      %v\noffline  %v\n", p.Leader, p.Replicas, p.ISR, p.Offline)
+				return nil
+			}
+		}
+		return fmt.Errorf("partition %s does not exist", partitionName(ts.Name, int32(id)))
+	case "check":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: check <topic>")
+		}
+		c := *s
+		c.topics = []string{args[1]}
+		c.emit = nil
+		c.webhook = nil
+		c.resumeFrom = ""
+		c.checkpoint = nil
+		rep, err := c.scan(context.Background())
+		if err != nil {
+			return err
+		}
+		return writeText(out, rep)
+	}
+	return fmt.Errorf("unknown command %q, type help for the list of commands", args[0])
+}
+
+// replTopic fetches the metadata of a topic
+func replTopic(s *scanner, topic string) (*topicState, error) {
+	state, err := fetchClusterState(s.client, []string{topic})
+	if err != nil {
+		return nil, err
+	}
+	ts, ok := state.Topics[topic]
+	if !ok {
+		return nil, fmt.Errorf("topic %s does not exist", topic)
+	}
+	if ts.Err != "" {
+		return nil, fmt.Errorf("topic %s: %s", topic, ts.Err)
+	}
+	return ts, nil
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// categories of failures
+const (
+	categoryUnderReplicated  = "under_replicated"         // the partition doesn't have the expected number of replicas
+	categoryOffline          = "offline"                  // the partition has no leader
+	categoryDuplicateReplica = "duplicate_replica"        // the same broker is assigned twice to the partition
+	categoryOverReplicated   = "over_replicated"          // the partition has more replicas assigned than the other partitions of its topic
+	categoryColocated        = "colocated_replicas"       // replicas of the partition share a rack or a host
+	categoryUnderMinISR      = "under_min_isr"            // the partition has fewer in-sync replicas than its min.insync.replicas
+	categoryInconsistent     = "inconsistent_metadata"    // the brokers disagree on the replicas or the ISR of the partition
+	categoryLeaderMismatch   = "leader_metadata_mismatch" // the leader of the partition disagrees with the controller on its replicas or ISR
+	categoryLeaderNotReplica = "leader_not_in_replicas"   // the leader of the partition is not one of its assigned replicas
+	categoryOutOfSync        = "out_of_sync"              // some assigned replicas of the partition are not in its ISR
+)
+
+// severities of the failures, and of the healthy partitions
+const (
+	severityOK         = "OK"
+	severityWarn       = "WARN"       // the failure doesn't make the run fail
+	severityCritical   = "CRITICAL"   // the failure makes the run fail
+	severityPersistent = "PERSISTENT" // the partition was not seen healthy for -unhealthyFor, across runs
+)
+
+// failure describes a partition that is not fully replicated
+type failure struct {
+	Topic      string                  `json:"topic"`
+	Partition  int32                   `json:"partition"`
+	Category   string                  `json:"category"`
+	Severity   string                  `json:"severity"`
+	Expected   int                     `json:"expected"`
+	Replicas   []int32                 `json:"replicas"`
+	ISR        []int32                 `json:"isr"`
+	Duplicates []int32                 `json:"duplicates,omitempty"` // brokers listed more than once in the replicas
+	OutOfSync  []int32                 `json:"outOfSync,omitempty"`  // assigned replicas missing from the ISR
+	Colocated  map[string][]int32      `json:"colocated,omitempty"`  // replicas sharing a failure domain, by domain
+	Views      map[int32]partitionView `json:"views,omitempty"`      // divergent views of the partition, by broker
+	Leader     *int32                  `json:"leader,omitempty"`     // leader of the partition, when it is not one of its replicas
+}
+
+func (f failure) String() string {
+	switch f.Category {
+	case categoryOffline:
+		return fmt.Sprintf("topics %s:%d has no leader", f.Topic, f.Partition)
+	case categoryDuplicateReplica:
+		return fmt.Sprintf("topics %s:%d has duplicate replicas %v", f.Topic, f.Partition, f.Duplicates)
+	case categoryColocated:
+		return fmt.Sprintf("topics %s:%d has replicas sharing a failure domain %v", f.Topic, f.Partition, f.Colocated)
+	case categoryInconsistent:
+		return fmt.Sprintf("topics %s:%d is seen differently by the brokers", f.Topic, f.Partition)
+	case categoryOutOfSync:
+		return fmt.Sprintf("topics %s:%d has replicas %v out of sync", f.Topic, f.Partition, f.OutOfSync)
+	case categoryLeaderNotReplica:
+		return fmt.Sprintf("topics %s:%d is led by broker %d, not one of its replicas %v", f.Topic, f.Partition, *f.Leader, f.Replicas)
+	case categoryLeaderMismatch:
+		return fmt.Sprintf("topics %s:%d is seen differently by its leader and the controller", f.Topic, f.Partition)
+	case categoryUnderMinISR:
+		return fmt.Sprintf("topics %s:%d has %d in-sync replicas, below its min.insync.replicas of %d, acks=all producers are rejected", f.Topic, f.Partition, len(f.ISR), f.Expected)
+	case categoryOverReplicated:
+		return fmt.Sprintf("topics %s:%d has %d replicas instead of %d", f.Topic, f.Partition, len(f.Replicas), f.Expected)
+	default:
+		return fmt.Sprintf("topics %s:%d is not fully replicated", f.Topic, f.Partition)
+	}
+}
+
+// report is the result of a scan of the cluster
+type report struct {
+	Name          string          `json:"name"`                      // name of the health check, set by -name
+	ScanID        string          `json:"scanID"`                    // random ID of the scan, also found in its logs
+	Cluster       string          `json:"cluster"`                   // ID of the cluster
+	Time          time.Time       `json:"time"`                      // when the scan started
+	Duration      float64         `json:"durationSeconds"`           // how long the scan took
+	Checked       int             `json:"checked"`                   // number of partitions checked
+	Unhealthy     int             `json:"partitions"`                // number of partitions with at least one failure
+	Percent       float64         `json:"percent"`                   // percentage of the checked partitions that are unhealthy
+	Failed        bool            `json:"failed"`                    // the unhealthy partitions exceed the fail thresholds
+	Total         int             `json:"total"`                     // number of failures, including the truncated ones
+	Truncated     bool            `json:"truncated"`                 // some failures are left out of Failures
+	Failures      []failure       `json:"failures"`                  // details of the failures
+	NoLeader      []string        `json:"noLeader,omitempty"`        // partitions without a leader, failing the check with -leaderUnavailableIsCritical
+	Escalated     []string        `json:"escalated,omitempty"`       // partitions escalated to CRITICAL after -escalateAfter consecutive failing scans
+	Recovered     []string        `json:"recovered,omitempty"`       // partitions not fully replicated that recovered within -isrGracePeriod
+	Warnings      warnings        `json:"warnings,omitempty"`        // conditions that don't fail the check but deserve attention
+	Components    []*component    `json:"components,omitempty"`      // internal topics checked explicitly
+	TimedOut      []string        `json:"timedOut,omitempty"`        // topics not completely checked within -perTopicTimeout
+	Skipped       []string        `json:"inconclusive,omitempty"`    // topics whose partitions couldn't be listed, skipped with -onListError=skip
+	Deleting      []string        `json:"deleting,omitempty"`        // topics being deleted, not checked
+	Levels        map[string]int  `json:"replicaLevels,omitempty"`   // replica level required for each topic checked, with -minReplicaLevelFromConfig
+	TopicDrift    *topicDrift     `json:"topicDrift,omitempty"`      // topics created or deleted, with -failIfTopicsAppearedOrDisappeared
+	BadNames      []string        `json:"badNames,omitempty"`        // topics not matching the -nameConvention
+	SingleReplica *singleReplicas `json:"singleReplica,omitempty"`   // partitions with a single replica, with -auditSingleReplica
+	Tiers         []tierResult    `json:"tiers,omitempty"`           // results grouped by replica tier
+	Leaders       *leaderStats    `json:"leaders,omitempty"`         // partitions not led by their preferred replica
+	LeaderBalance []topicLeaders  `json:"leaderBalance,omitempty"`   // spread of the leaders of the -leaderBalanceTopics over the brokers
+	Preferred     []leaderSpread  `json:"preferred,omitempty"`       // spread of the preferred leaders of each topic over the brokers, with -maxPreferredLeaderImbalance
+	Controller    *controllerInfo `json:"controller,omitempty"`      // changes of controller within -controllerChangesWindow, with -maxControllerChanges
+	Balance       *replicaBalance `json:"balance,omitempty"`         // spread of the replicas over the brokers
+	Baseline      *baselineDiff   `json:"baseline,omitempty"`        // differences with the baseline report, if any
+	MissingACLs   []aclAssertion  `json:"missingACLs,omitempty"`     // expected ACLs not found in the cluster
+	LiveBrokers   []int32         `json:"liveBrokers"`               // IDs of the brokers currently part of the cluster
+	Probes        []brokerProbe   `json:"connectivity,omitempty"`    // connection to each live broker, with -probeAllBrokers
+	Canary        *canaryResult   `json:"canary,omitempty"`          // round trip of a message through the -canaryTopic
+	Racks         brokerRacks     `json:"brokerRacks"`               // rack label of the live brokers, by ID, empty if the broker has none
+	Excluded      []int32         `json:"excludedBrokers,omitempty"` // IDs of the brokers ignored by the checks, set by -excludeBrokers
+	MinBrokers    int             `json:"minBrokers,omitempty"`      // minimum number of live brokers, set by -minBrokers
+	TooFewLive    bool            `json:"tooFewBrokers"`             // fewer brokers than MinBrokers are live
+	Broker        *brokerStats    `json:"broker,omitempty"`          // role of the broker targeted by -brokerID
+
+	state *clusterState // metadata snapshot the checks worked on
+	all   []failure     // all the failures, when Failures is truncated
+}
+
+// Healthy returns true if the report doesn't make the check fail
+func (r *report) Healthy() bool {
+	return !r.Failed && len(r.MissingACLs) == 0 && !r.TooFewLive && (r.Leaders == nil || !r.Leaders.Failed) &&
+		(r.Balance == nil || !r.Balance.Failed) && !r.leadersUnbalanced() && !r.preferredUnbalanced() &&
+		(r.Controller == nil || !r.Controller.Failed) && (r.SingleReplica == nil || !r.SingleReplica.Failed) &&
+		(r.Canary == nil || !r.Canary.Failed)
+}
+
+// leadersUnbalanced returns true if the leadership of a topic checked for
+// balance is not evenly spread
+func (r *report) leadersUnbalanced() bool {
+	for _, l := range r.LeaderBalance {
+		if l.Failed {
+			return true
+		}
+	}
+	return false
+}
+
+// preferredUnbalanced returns true if the preferred leaders of a topic are
+// not evenly spread
+func (r *report) preferredUnbalanced() bool {
+	for _, l := range r.Preferred {
+		if l.Failed {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate returns a copy of the report with at most max detailed failures. A
+// max of 0 or less means no limit
+func (r *report) truncate(max int) *report {
+	t := *r
+	t.Failures, t.Truncated = truncateFailures(r.Failures, max)
+	t.all = r.allFailures()
+	return &t
+}
+
+// allFailures returns all the failures, including the truncated ones
+func (r *report) allFailures() []failure {
+	if r.all != nil {
+		return r.all
+	}
+	return r.Failures
+}
+
+// logReport logs the missing brokers, the role of the targeted broker, the
+// internal topics and tiers checked, the leaders not preferred, the balance
+// of the replicas, the topics
+// badly named or being deleted, each failure and missing ACL, the differences
+// with the baseline, and a summary of the failures
+func logReport(logger *logrus.Logger, r *report) {
+	log := logger.WithField("scanID", r.ScanID)
+
+	if len(r.NoLeader) > 0 {
+		log.WithFields(logrus.Fields{
+			"partitions": r.NoLeader,
+		}).Errorf("%d partitions have no leader, their data is unavailable", len(r.NoLeader))
+	}
+
+	if r.TooFewLive {
+		log.WithFields(logrus.Fields{
+			"liveBrokers": r.LiveBrokers,
+			"live":        len(r.LiveBrokers),
+			"minBrokers":  r.MinBrokers,
+		}).Errorf("only %d brokers are live, expected at least %d", len(r.LiveBrokers), r.MinBrokers)
+	}
+
+	for _, p := range r.Probes {
+		if p.Reachable {
+			log.WithFields(logrus.Fields{
+				"broker":  p.ID,
+				"addr":    p.Addr,
+				"latency": p.Latency,
+			}).Infof("broker %d reached in %.3fs", p.ID, p.Latency)
+		}
+	}
+
+	if c := r.Canary; c != nil {
+		entry := log.WithFields(logrus.Fields{
+			"topic":     c.Topic,
+			"partition": c.Partition,
+			"offset":    c.Offset,
+			"latency":   c.Latency,
+			"deadline":  c.Deadline,
+		})
+		if c.Failed {
+			entry.Errorf("canary message through topic %s failed: %s", c.Topic, c.Err)
+		} else {
+			entry.Infof("canary message through topic %s made the round trip in %.3fs", c.Topic, c.Latency)
+		}
+	}
+
+	if stats := r.Broker; stats != nil {
+		entry := log.WithFields(logrus.Fields{
+			"broker":    stats.ID,
+			"live":      stats.Live,
+			"rack":      stats.Rack,
+			"replicas":  stats.Replicas,
+			"inSync":    stats.InSync,
+			"leader":    stats.Leader,
+			"outOfSync": stats.OutOfSync,
+		})
+		if !stats.Live || len(stats.OutOfSync) > 0 {
+			entry.Warnf("broker %d has %d replicas out of sync", stats.ID, len(stats.OutOfSync))
+		} else {
+			entry.Infof("broker %d has all its %d replicas in sync", stats.ID, stats.Replicas)
+		}
+	}
+
+	for _, c := range r.Components {
+		entry := log.WithFields(logrus.Fields{
+			"component":  c.Name,
+			"topic":      c.Topic,
+			"exists":     c.Exists,
+			"expected":   c.Expected,
+			"partitions": c.Partitions,
+			"unhealthy":  c.Unhealthy,
+			"count":      c.Count,
+		})
+		switch {
+		case !c.Exists:
+			entry.Infof("%s topic %s does not exist yet", c.Name, c.Topic)
+		case c.miscounted():
+			entry.Warnf("%s topic %s has %d partitions instead of %d", c.Name, c.Topic, c.Count, c.Wanted)
+		case !c.Healthy():
+			entry.Warnf("%s topic %s has %d partitions not healthy", c.Name, c.Topic, c.Unhealthy)
+		default:
+			entry.Infof("%s topic %s is healthy", c.Name, c.Topic)
+		}
+	}
+
+	for _, t := range r.Tiers {
+		entry := log.WithFields(logrus.Fields{
+			"tier":         t.Name,
+			"replicaLevel": t.ReplicaLevel,
+			"topics":       t.Topics,
+			"partitions":   t.Partitions,
+			"unhealthy":    t.Unhealthy,
+		})
+		if t.Passed {
+			entry.Infof("tier %s passed", t.Name)
+		} else {
+			entry.Warnf("tier %s has %d partitions not healthy", t.Name, t.Unhealthy)
+		}
+	}
+
+	if l := r.Leaders; l != nil && len(l.NonPreferred) > 0 {
+		entry := log.WithFields(logrus.Fields{
+			"nonPreferred": l.NonPreferred,
+			"percent":      l.Percent,
+			"maxPercent":   l.MaxPercent,
+		})
+		if l.Failed {
+			entry.Errorf("%d partitions are not led by their preferred replica, a preferred replica election is needed", len(l.NonPreferred))
+		} else {
+			entry.Warnf("%d partitions are not led by their preferred replica", len(l.NonPreferred))
+		}
+	}
+
+	if len(r.Recovered) > 0 {
+		log.WithFields(logrus.Fields{
+			"recovered": r.Recovered,
+			"total":     len(r.Recovered),
+		}).Infof("%d partitions recovered within the grace period", len(r.Recovered))
+	}
+
+	for _, l := range r.LeaderBalance {
+		entry := log.WithFields(logrus.Fields{
+			"topic":    l.Topic,
+			"leaders":  l.Leaders,
+			"max":      l.Max,
+			"mean":     l.Mean,
+			"ratio":    l.Ratio,
+			"maxRatio": l.MaxRatio,
+		})
+		if l.Failed {
+			entry.Errorf("leaders of topic %s are not balanced over the brokers, ratio %.2f above %.2f", l.Topic, l.Ratio, l.MaxRatio)
+		} else {
+			entry.Infof("leaders of topic %s are balanced over the brokers, ratio %.2f", l.Topic, l.Ratio)
+		}
+	}
+
+	for _, l := range r.Preferred {
+		entry := log.WithFields(logrus.Fields{
+			"topic":    l.Topic,
+			"leaders":  l.Leaders,
+			"max":      l.Max,
+			"ideal":    l.Ideal,
+			"ratio":    l.Ratio,
+			"maxRatio": l.MaxRatio,
+		})
+		if l.Failed {
+			entry.Errorf("preferred leaders of topic %s are not spread over the brokers, ratio %.2f above %.2f", l.Topic, l.Ratio, l.MaxRatio)
+		} else {
+			entry.Debugf("preferred leaders of topic %s are spread over the brokers, ratio %.2f", l.Topic, l.Ratio)
+		}
+	}
+
+	if c := r.Controller; c != nil && c.Failed {
+		log.WithFields(logrus.Fields{
+			"controller": c.ID,
+			"changes":    c.Changes,
+			"window":     c.Window,
+			"max":        c.Max,
+		}).Errorf("controller changed %d times within %.0fs, more than %d, the cluster is unstable", c.Changes, c.Window, c.Max)
+	}
+
+	if b := r.Balance; b != nil {
+		entry := log.WithFields(logrus.Fields{
+			"replicas": b.Replicas,
+			"max":      b.Max,
+			"mean":     b.Mean,
+			"ratio":    b.Ratio,
+			"maxRatio": b.MaxRatio,
+		})
+		if b.Failed {
+			entry.Errorf("replicas are not balanced over the brokers, ratio %.2f above %.2f, a rebalance is needed", b.Ratio, b.MaxRatio)
+		} else {
+			entry.Infof("replicas are balanced over the brokers, ratio %.2f", b.Ratio)
+		}
+	}
+
+	if d := r.TopicDrift; d != nil {
+		log.WithFields(logrus.Fields{
+			"added":   d.Added,
+			"removed": d.Removed,
+		}).Errorf("%d topics appeared and %d disappeared", len(d.Added), len(d.Removed))
+	}
+
+	if len(r.BadNames) > 0 {
+		log.WithFields(logrus.Fields{
+			"badNames": r.BadNames,
+			"total":    len(r.BadNames),
+		}).Warnf("%d topics don't match the naming convention", len(r.BadNames))
+	}
+
+	if a := r.SingleReplica; a != nil && a.Partitions > 0 {
+		entry := log.WithFields(logrus.Fields{
+			"topics":     a.topicNames(),
+			"partitions": a.Partitions,
+		})
+		if a.Failed {
+			entry.Errorf("%d partitions of %d topics have a single replica", a.Partitions, len(a.Topics))
+		} else {
+			entry.Warnf("%d partitions of %d topics have a single replica", a.Partitions, len(a.Topics))
+		}
+	}
+
+	for _, topic := range r.Deleting {
+		log.WithFields(logrus.Fields{
+			"topic": topic,
+		}).Warnf("topic %s is being deleted", topic)
+	}
+
+	for _, a := range r.MissingACLs {
+		log.WithFields(logrus.Fields{
+			"principal":    a.Principal,
+			"operation":    a.Operation,
+			"resourceType": a.ResourceType,
+			"resourceName": a.ResourceName,
+		}).Errorf("ACL %s is missing", a)
+	}
+
+	if d := r.Baseline; d != nil {
+		entry := log.WithFields(logrus.Fields{
+			"policy":  d.Policy,
+			"added":   d.Added,
+			"removed": d.Removed,
+			"changed": d.Changed,
+		})
+		if len(d.regressions()) > 0 {
+			entry.Errorf("%d failures added and %d changed since the baseline", len(d.Added), len(d.Changed))
+		} else {
+			entry.Infof("%d failures added, %d removed and %d changed since the baseline", len(d.Added), len(d.Removed), len(d.Changed))
+		}
+	}
+
+	if r.Total > 0 {
+		level := logrus.WarnLevel
+		if r.Failed {
+			level = logrus.ErrorLevel
+		}
+		for _, f := range r.Failures {
+			logf(log.WithFields(logrus.Fields{
+				"topic":     f.Topic,
+				"partition": f.Partition,
+				"category":  f.Category,
+				"severity":  f.Severity,
+				"expected":  f.Expected,
+				"replica":   f.Replicas,
+			}), level, "%s", f)
+		}
+		// the summary names every failing partition, even when their
+		// details are truncated
+		logf(log.WithFields(logrus.Fields{
+			"failures":   r.Failures,
+			"unhealthy":  failingPartitions(r.allFailures()),
+			"total":      r.Total,
+			"partitions": r.Unhealthy,
+			"checked":    r.Checked,
+			"percent":    r.Percent,
+			"truncated":  r.Truncated,
+		}), level, "%d partitions are not healthy", r.Unhealthy)
+	}
+	if len(r.MissingACLs) > 0 {
+		log.WithFields(logrus.Fields{
+			"missingACLs": r.MissingACLs,
+			"total":       len(r.MissingACLs),
+		}).Errorf("%d expected ACLs are missing", len(r.MissingACLs))
+	}
+}
+
+// warnings collects the conditions found by a scan that don't fail the check
+// but deserve attention, so they are found in the report and not only in the
+// logs
+type warnings []string
+
+// add logs a warning, and collects it
+func (w *warnings) add(entry *logrus.Entry, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	entry.Warn(msg)
+	*w = append(*w, msg)
+}
+
+// logf logs a formatted message at the given level, which the vendored logrus
+// does not provide
+func logf(entry *logrus.Entry, level logrus.Level, format string, args ...interface{}) {
+	switch level {
+	case logrus.ErrorLevel:
+		entry.Errorf(format, args...)
+	case logrus.WarnLevel:
+		entry.Warnf(format, args...)
+	case logrus.InfoLevel:
+		entry.Infof(format, args...)
+	default:
+		entry.Debugf(format, args...)
+	}
+}
+
+// countPartitions returns the number of distinct partitions in the failures,
+// as a partition can fail for several reasons
+func countPartitions(failures []failure) int {
+	partitions := make(map[string]bool)
+	for _, f := range failures {
+		partitions[fmt.Sprintf("%s:%d", f.Topic, f.Partition)] = true
+	}
+	return len(partitions)
+}
+
+// failingPartitions returns the topic:partition of the partitions in the
+// failures, once each, in the order they failed
+func failingPartitions(failures []failure) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, f := range failures {
+		if name := partitionName(f.Topic, f.Partition); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// failurePercent returns the percentage of failed partitions out of the
+// checked ones
+func failurePercent(failed, checked int) float64 {
+	if checked == 0 {
+		return 0
+	}
+	return float64(failed) * 100 / float64(checked)
+}
+
+// exceedsFailThreshold returns true when the failed partitions should make the
+// run fail: either there are at least count of them (if count is set), or they
+// represent more than percent of the checked partitions
+func exceedsFailThreshold(failed, checked int, percent float64, count int) bool {
+	if failed == 0 {
+		return false
+	}
+	if count > 0 && failed >= count {
+		return true
+	}
+	return failurePercent(failed, checked) > percent
+}
+
+// truncateFailures returns at most max failures, and whether some were left
+// out. A max of 0 or less means no limit
+func truncateFailures(failures []failure, max int) ([]failure, bool) {
+	if max <= 0 || len(failures) <= max {
+		return failures, false
+	}
+	return failures[:max], true
+}
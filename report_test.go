@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+func TestWarningsAdd(t *testing.T) {
+	rec := &entryRecorder{}
+	log := newTestLogger(rec)
+	var warns warnings
+	warns.add(log.WithField("topic", "events"), "topic %s is %s", "events", "odd")
+	warns.add(log.WithField("topic", "orders"), "topic %s is %s", "orders", "odd")
+
+	want := warnings{"topic events is odd", "topic orders is odd"}
+	if !reflect.DeepEqual(warns, want) {
+		t.Errorf("warnings = %q, want %q", warns, want)
+	}
+	logged := rec.messages(logrus.WarnLevel)
+	for _, msg := range want {
+		if logged[msg] == nil {
+			t.Errorf("warning %q not logged", msg)
+		}
+	}
+}
+
+func TestScanWarningsDontFail(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	meta := newTestMetadata(broker)
+	meta.AddTopicPartition("events", 0, 1, []int32{1}, []int32{1}, sarama.ErrNoError)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(meta),
+	})
+	s, _ := newTestScanner(t, broker, "events")
+	defer s.client.Close()
+	// a healthy cluster, with a partition asked for that doesn't exist
+	s.partitions = map[string][]int32{"events": {0, 7}}
+
+	defer func(level int) { *replicaLevel = level }(*replicaLevel)
+	*replicaLevel = 1
+	rep, err := s.scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (warnings{"partition events:7 does not exist"}); !reflect.DeepEqual(rep.Warnings, want) {
+		t.Errorf("warnings = %q, want %q", rep.Warnings, want)
+	}
+	if !rep.Healthy() || exitCode(rep) != 0 {
+		t.Errorf("report with warnings only is unhealthy, exit code %d: %+v", exitCode(rep), rep)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// saramaLogger forwards the logs of the sarama client to our logger, at
+// debug level. It implements sarama.StdLogger
+type saramaLogger struct {
+	entry *logrus.Entry
+}
+
+func newSaramaLogger(log *logrus.Logger) *saramaLogger {
+	return &saramaLogger{
+		entry: log.WithField("component", "sarama"),
+	}
+}
+
+func (l *saramaLogger) Print(v ...interface{}) {
+	l.entry.Debug(strings.TrimSuffix(fmt.Sprint(v...), "\n"))
+}
+
+func (l *saramaLogger) Printf(format string, v ...interface{}) {
+	l.entry.Debug(strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"))
+}
+
+func (l *saramaLogger) Println(v ...interface{}) {
+	l.entry.Debug(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// SASL mechanisms of -saslMechanism
+const (
+	saslPlain       = "PLAIN"
+	saslScramSHA256 = "SCRAM-SHA-256"
+	saslScramSHA512 = "SCRAM-SHA-512"
+	saslOAuthBearer = "OAUTHBEARER"
+	saslGSSAPI      = "GSSAPI"
+)
+
+// configureSASL enables the SASL authentication of the connections to the
+// brokers, when a mechanism is set. Only PLAIN is supported by the vendored
+// sarama, the SCRAM, OAUTHBEARER and GSSAPI mechanisms are rejected rather
+// than silently downgraded. SCRAM needs sarama v1.20.0 or later, and
+// golang.org/x/crypto/pbkdf2, neither vendored yet
+func configureSASL(config *sarama.Config, mechanism, user, password string) error {
+	switch strings.ToUpper(mechanism) {
+	case "":
+		if user != "" || password != "" {
+			return fmt.Errorf("saslUser and saslPassword need a saslMechanism")
+		}
+		return nil
+	case saslPlain:
+	case saslScramSHA256, saslScramSHA512, saslOAuthBearer, saslGSSAPI:
+		return fmt.Errorf("SASL mechanism %s is not supported by the vendored sarama (v1.19.0), only %s is", strings.ToUpper(mechanism), saslPlain)
+	default:
+		return fmt.Errorf("unknown SASL mechanism %q, must be %s", mechanism, saslPlain)
+	}
+	if user == "" {
+		return fmt.Errorf("SASL mechanism %s needs a saslUser", saslPlain)
+	}
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.User = user
+	config.Net.SASL.Password = password
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestConfigureSASL(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		user      string
+		wantErr   bool
+	}{
+		{"", "", false},
+		{"", "user", true},
+		{"PLAIN", "user", false},
+		{"plain", "user", false},
+		{"PLAIN", "", true},
+		// not supported by the vendored sarama
+		{"SCRAM-SHA-256", "user", true},
+		{"SCRAM-SHA-512", "user", true},
+		{"OAUTHBEARER", "user", true},
+		{"GSSAPI", "user", true},
+		{"DIGEST-MD5", "user", true},
+	}
+	for _, tt := range tests {
+		password := ""
+		if tt.user != "" {
+			password = "secret"
+		}
+		config := sarama.NewConfig()
+		err := configureSASL(config, tt.mechanism, tt.user, password)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("configureSASL(%q, %q) error = %v, want error %v", tt.mechanism, tt.user, err, tt.wantErr)
+		}
+		if enabled := err == nil && tt.mechanism != ""; config.Net.SASL.Enable != enabled {
+			t.Errorf("configureSASL(%q, %q) enabled SASL = %v, want %v", tt.mechanism, tt.user, config.Net.SASL.Enable, enabled)
+		}
+	}
+}
@@ -0,0 +1,908 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prune998/kafka-health/health"
+	"github.com/sirupsen/logrus"
+)
+
+// scanner checks the health of a cluster
+type scanner struct {
+	client         sarama.Client
+	brokers        []string           // bootstrap brokers, used to start the cluster admin
+	config         *sarama.Config     // config of the client, used to start the cluster admin
+	topics         []string           // topics to check, all the topics of the cluster if empty
+	assertions     []aclAssertion     // ACLs expected to exist
+	partitions     map[string][]int32 // partitions to check by topic, all of them for the topics not listed
+	baseline       *report            // previous report, only the new failures fail the check if set
+	tiers          []replicaTier      // replica levels of the topics matching each tier
+	emit           func(failure)      // called with each failure as soon as it is found, if set
+	nameConvention *regexp.Regexp     // names the topics must match, if set
+	excluded       []int32            // brokers ignored by the checks, as if they were not part of the cluster
+	excludedTopics *topicExclusion    // topics left out of the scan, if set
+	redactor       *topicRedactor     // masks the sensitive topic names in the report, if set
+	knownTopics    map[string]bool    // reference set of topics of -failIfTopicsAppearedOrDisappeared
+	syslog         *syslogWriter      // receives the summary of the failing scans, if set
+	webhook        *webhook           // receives the results of the scans as CloudEvents, if set
+	resumeFrom     string             // topics sorted up to this one are skipped
+	checkpoint     *checkpoint        // records the progress of the scan, if set
+	limiter        *limiter           // throttles the requests sent to the brokers, if set
+	streaks        map[string]int     // consecutive scans each failing partition failed, by topic:partition
+	reconnects     int64              // number of reconnections to the controller, updated atomically
+	controller     int32              // ID of the controller seen by the last scan
+	controllerSeen bool               // a scan saw a controller already
+	flaps          []time.Time        // when the controller changed, within -controllerChangesWindow
+	flapCount      int64              // number of changes of controller, updated atomically
+	log            *logrus.Logger
+}
+
+// scan checks the cluster once and returns the report of the checks. An error
+// is returned when the cluster can't be checked at all, or when ctx is done
+// before the end of the scan, or -scanTimeout elapsed. The sensitive topic
+// names are masked in both. The failing scans are sent to syslog, and all of
+// them to the webhook, if set
+func (s *scanner) scan(ctx context.Context) (*report, error) {
+	if *scanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *scanTimeout)
+		defer cancel()
+	}
+	rep, err := s.check(ctx)
+	if err != nil {
+		err = s.redactor.error(err)
+		s.syslog.error(err)
+		s.webhook.error(err)
+		return nil, err
+	}
+	rep = s.redactor.report(rep)
+	s.syslog.report(rep)
+	s.webhook.report(rep)
+	return rep, nil
+}
+
+// what to do with a topic whose partitions can't be listed, set by
+// -onListError
+const (
+	listErrorFail = "fail" // the scan fails
+	listErrorSkip = "skip" // the topic is skipped, and reported as inconclusive
+)
+
+// interrupted returns an error if ctx is done, naming the step the scan was
+// about to start
+func interrupted(ctx context.Context, step string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("scan interrupted before %s: %s", step, err)
+	}
+	return nil
+}
+
+// check runs the checks on the cluster, with the real topic names. ctx is
+// checked between the steps of the scan and between the topics: a request
+// already sent to a broker is not interrupted, the sarama client doesn't take
+// a context
+func (s *scanner) check(ctx context.Context) (*report, error) {
+	start := time.Now()
+	id := newScanID()
+	log := s.log.WithField("scanID", id)
+
+	// the internal topics checked explicitly are always part of the scan
+	var topics []string
+	if len(s.topics) > 0 {
+		topics = append(topics, s.topics...)
+		for _, t := range enabledInternalTopics() {
+			if !containsTopic(topics, t.Topic) {
+				topics = append(topics, t.Topic)
+			}
+		}
+	}
+
+	// gather a snapshot of the cluster metadata, shared by all the checks
+	// if no topic is provided, get the metadata of all the topics from Kafka
+	// the wait for the rate limit is given up when ctx is done, the scan
+	// then stops before the step it was waiting for
+	s.limiter.wait(ctx)
+	if err := interrupted(ctx, "fetching metadata"); err != nil {
+		return nil, err
+	}
+	state, err := fetchClusterState(s.client, topics)
+	if err != nil {
+		atomic.AddInt64(&s.reconnects, 1)
+		return nil, fmt.Errorf("error fetching metadata: %s", err)
+	}
+	s.redactor.learn(state)
+	// topics are scanned in sorted order, so a scan can be resumed
+	topicsList := state.TopicNames()
+	if len(topics) > 0 {
+		topicsList = topics
+	}
+	// the excluded topics are left out, but not the internal topics checked
+	// explicitly
+	topicsList, skipped := s.excludedTopics.filter(topicsList)
+	for _, t := range enabledInternalTopics() {
+		if !containsTopic(topicsList, t.Topic) {
+			topicsList = append(topicsList, t.Topic)
+		}
+	}
+	sort.Strings(topicsList)
+
+	var warns warnings
+
+	// a replica level above the number of brokers can't be satisfied, and
+	// would make every partition fail
+	for name, level := range s.replicaLevels() {
+		if level > len(state.Brokers) {
+			warns.add(log.WithFields(logrus.Fields{
+				"flag":    name,
+				"level":   level,
+				"brokers": len(state.Brokers),
+			}), "%s %d is higher than the %d live brokers, the partitions can't be fully replicated", name, level, len(state.Brokers))
+		}
+	}
+
+	// a broker without a rack in a rack aware cluster is usually a
+	// misconfiguration, and breaks the rack awareness of its replicas
+	if missing := state.Racks().missing(); len(missing) > 0 {
+		warns.add(log.WithFields(logrus.Fields{
+			"brokers": missing,
+		}), "brokers %v have no rack while the others have one", missing)
+	}
+
+	// connect to every broker before the checks, to tell a network issue
+	// with a broker from a replication issue
+	var probes []brokerProbe
+	if *probeAll {
+		probes = probeBrokers(state, s.config)
+		for _, p := range probes {
+			if !p.Reachable {
+				warns.add(log.WithFields(logrus.Fields{
+					"broker": p.ID,
+					"addr":   p.Addr,
+					"err":    p.Err,
+				}), "broker %d at %s can't be reached: %s", p.ID, p.Addr, p.Err)
+			}
+		}
+	}
+
+	// the partitions are checked as if the excluded brokers were gone
+	if len(s.excluded) > 0 {
+		warns.add(log.WithFields(logrus.Fields{
+			"excludedBrokers": s.excluded,
+		}), "brokers %v are excluded from the checks", s.excluded)
+	}
+
+	// debug the list of topics to check
+	log.WithFields(logrus.Fields{
+		"topics":     topicsList,
+		"len":        len(topicsList),
+		"excluded":   skipped,
+		"brokers":    len(state.Brokers),
+		"controller": state.Controller,
+	}).Debug("topic list generated")
+
+	// get the metadata as seen by each broker, to compare it to the one of
+	// the controller
+	if err := interrupted(ctx, "checking the topics"); err != nil {
+		return nil, err
+	}
+	var views map[int32]*clusterState
+	if *verifyMeta {
+		views = s.fetchBrokerViews(ctx, log, topics, nil)
+	}
+	// get the metadata as seen by the leaders, to compare each partition to
+	// the view of its leader
+	leaderViews := views
+	if *compareLeader && views == nil {
+		leaderViews = s.fetchBrokerViews(ctx, log, topics, leaderIDs(state))
+	}
+
+	// get the configs of the topics needed by the checks: the
+	// min.insync.replicas to find the partitions rejecting the acks=all
+	// producers, the cleanup.policy to find the compacted topics
+	var configNames []string
+	if *checkMinISR {
+		configNames = append(configNames, "min.insync.replicas")
+	}
+	if *maxCompactedSpan > 0 {
+		configNames = append(configNames, "cleanup.policy")
+	}
+	var configs map[string]map[string]string
+	if len(configNames) > 0 {
+		var existing []string
+		for _, topic := range topicsList {
+			if ts, ok := state.Topics[topic]; ok && ts.Err == "" {
+				existing = append(existing, topic)
+			}
+		}
+		s.limiter.wait(ctx)
+		if err := interrupted(ctx, "describing topic configs"); err != nil {
+			return nil, err
+		}
+		configs, err = fetchTopicConfigs(s.client, existing, configNames)
+		if err != nil {
+			return nil, fmt.Errorf("error describing topic configs: %s", err)
+		}
+	}
+
+	// give the partitions not fully replicated a chance to recover, as
+	// after a broker restart
+	var recovered map[string]bool
+	if *isrGrace > 0 {
+		recovered = s.recheckAfterGrace(ctx, log, state, *isrGrace)
+	}
+
+	// fetch the spans of the compacted partitions concurrently, they are the
+	// only checks sending requests for each partition
+	spans := &offsetSpans{}
+	if *maxCompactedSpan > 0 {
+		spans = s.fetchOffsetSpans(ctx, state, topicsList, configs, *maxTopics, *maxPartitions)
+	}
+
+	// parse all topics for replication, collecting every failing partition
+	var failures []failure
+	record := func(f failure) {
+		failures = append(failures, f)
+		if s.emit != nil {
+			s.emit(s.redactor.failure(f))
+		}
+	}
+	var components []*component
+	var deleting []string
+	var timedOut []string
+	var inconclusive []string
+	tiers := make([]tierResult, len(s.tiers))
+	for i, t := range s.tiers {
+		tiers[i] = tierResult{Name: t.Name, ReplicaLevel: t.ReplicaLevel}
+	}
+	checked, led := 0, 0
+	var seen []string   // topic:partition of the partitions checked
+	var healed []string // topic:partition of the partitions that recovered within the grace period
+	var nonPreferred []string
+	var durations []topicDuration
+	var levels map[string]int
+	if *levelFromRF {
+		levels = make(map[string]int)
+	}
+	for _, topic := range topicsList {
+		if s.resumeFrom != "" && topic <= s.resumeFrom {
+			continue
+		}
+		// the checkpoint allows resuming an interrupted scan
+		if err := interrupted(ctx, "topic "+topic); err != nil {
+			s.checkpoint.flush()
+			return nil, err
+		}
+		topicStart := time.Now()
+		ts, ok := state.Topics[topic]
+		level := expectedReplicas(topic, ts, s.tiers)
+
+		// the internal topics checked explicitly are reported as components,
+		// even when they don't exist yet
+		var comp *component
+		if t, internal := findInternalTopic(topic); internal {
+			comp = &component{
+				Name:     t.Component,
+				Topic:    topic,
+				Exists:   ok && ts.Err != sarama.ErrUnknownTopicOrPartition.Error(),
+				Expected: level,
+			}
+			components = append(components, comp)
+			if !comp.Exists {
+				s.checkpoint.done(topic)
+				continue
+			}
+			// the number of partitions of an internal topic is set once
+			// and for all, when it is created
+			comp.Count = len(ts.Partitions)
+			if t.count != nil && *t.count > 0 {
+				comp.Wanted = *t.count
+			}
+			if comp.miscounted() {
+				warns.add(log.WithFields(logrus.Fields{
+					"component": comp.Name,
+					"topic":     topic,
+					"count":     comp.Count,
+					"wanted":    comp.Wanted,
+				}), "%s topic %s has %d partitions instead of %d, the cluster may be misconfigured or partially initialized", comp.Name, topic, comp.Count, comp.Wanted)
+			}
+		}
+		// a topic listed from the cluster that vanished, or left without
+		// replicas, is being deleted: report it apart instead of failing it
+		if ok && (ts.beingDeleted() || len(s.topics) == 0 && ts.Err == sarama.ErrUnknownTopicOrPartition.Error()) {
+			deleting = append(deleting, topic)
+			s.checkpoint.done(topic)
+			continue
+		}
+		if !ok || ts.Err != "" {
+			err := sarama.ErrUnknownTopicOrPartition.Error()
+			if ok {
+				err = ts.Err
+			}
+			// a flaky topic doesn't have to hold the assessment of the
+			// others
+			if *onListError == listErrorSkip {
+				inconclusive = append(inconclusive, topic)
+				warns.add(log.WithFields(logrus.Fields{
+					"topic": topic,
+					"err":   err,
+				}), "can't list the partitions of topic %s, skipped: %s", topic, err)
+				s.checkpoint.done(topic)
+				continue
+			}
+			s.checkpoint.flush()
+			return nil, fmt.Errorf("error listing partitions of topic %s: %s", topic, err)
+		}
+		// report the level each topic is held to, when it depends on the
+		// topic
+		if levels != nil {
+			levels[topic] = level
+			log.WithFields(logrus.Fields{
+				"topic":        topic,
+				"replicaLevel": level,
+			}).Debug("replica level of the topic")
+		}
+
+		// the results of the topics are grouped by tier
+		var tier *tierResult
+		if i := findTier(s.tiers, topic); i >= 0 {
+			tier = &tiers[i]
+			tier.Topics++
+		}
+
+		// warn about the requested partitions the topic doesn't have
+		wanted := s.partitions[topic]
+		for _, id := range wanted {
+			if !ts.hasPartition(id) {
+				warns.add(log.WithFields(logrus.Fields{
+					"topic":      topic,
+					"partition":  id,
+					"partitions": len(ts.Partitions),
+				}), "partition %s:%d does not exist", topic, id)
+			}
+		}
+
+		rf := ts.replicationFactor()
+
+		// a min.insync.replicas not lower than the replication factor
+		// leaves no room for a replica out of sync
+		if minInsync := intConfig(configs[topic], "min.insync.replicas"); *checkMinISR && rf > 0 && minInsync >= rf {
+			warns.add(log.WithFields(logrus.Fields{
+				"topic":             topic,
+				"minInsyncReplicas": minInsync,
+				"replicationFactor": rf,
+			}), "topic %s has min.insync.replicas %d for a replication factor of %d, its acks=all producers fail as soon as a replica is out of sync", topic, minInsync, rf)
+		}
+
+		// parse each partition and get replication status, until one
+		// whose offsets were not fetched within -perTopicTimeout. The
+		// other checks only read the snapshot, they can't time out
+		for _, p := range ts.Partitions {
+			partition := p.ID
+			if !s.selected(topic, p) {
+				continue
+			}
+			if spans.get(topic, partition).timedOut {
+				timedOut = append(timedOut, topic)
+				warns.add(log.WithFields(logrus.Fields{
+					"topic":     topic,
+					"partition": partition,
+					"timeout":   topicTimeout.String(),
+				}), "topic %s timed out after %s, its partitions from %d are not checked", topic, *topicTimeout, partition)
+				break
+			}
+			checked++
+			seen = append(seen, partitionName(topic, partition))
+			before := len(failures)
+
+			// the excluded brokers are ignored, and not expected to host
+			// replicas anymore
+			orig := p
+			p, removed := withoutBrokers(p, s.excluded)
+			level := level - removed
+
+			// find the number of replicas, ignoring the duplicated brokers
+			replicas, duplicates := health.Dedup(countReplicas(state, p))
+
+			log.WithFields(logrus.Fields{
+				"topic":     topic,
+				"partition": partition,
+				"replica":   replicas,
+				"duplicate": duplicates,
+				"mode":      *countMode,
+			}).Debug("found topic info")
+
+			// record the partition if it's not led by its preferred replica
+			if p.Leader >= 0 {
+				led++
+			}
+			if !hasPreferredLeader(p) {
+				nonPreferred = append(nonPreferred, partitionName(topic, partition))
+			}
+
+			// record the partition if its assignment lists a broker twice
+			if _, assigned := health.Dedup(p.Replicas); len(assigned) > 0 {
+				record(failure{
+					Topic:      topic,
+					Partition:  partition,
+					Category:   categoryDuplicateReplica,
+					Expected:   level,
+					Replicas:   p.Replicas,
+					ISR:        p.ISR,
+					Duplicates: assigned,
+				})
+			}
+
+			// record the partition if its leader is not one of its
+			// replicas, as left by a broken reassignment. The count of the
+			// replicas can't see it
+			if *leaderInRep && p.Leader >= 0 && !containsBroker(orig.Replicas, p.Leader) {
+				leader := p.Leader
+				record(failure{
+					Topic:     topic,
+					Partition: partition,
+					Category:  categoryLeaderNotReplica,
+					Expected:  level,
+					Replicas:  orig.Replicas,
+					ISR:       p.ISR,
+					Leader:    &leader,
+				})
+			}
+
+			// record the partition if it has more replicas than its topic,
+			// as left by an incomplete reassignment
+			if *checkOverRep && len(p.Replicas) > rf {
+				record(failure{
+					Topic:     topic,
+					Partition: partition,
+					Category:  categoryOverReplicated,
+					Expected:  rf,
+					Replicas:  p.Replicas,
+					ISR:       p.ISR,
+				})
+			}
+
+			// record the partition if some of its replicas share a failure
+			// domain, when they are required not to
+			if *antiAffinity {
+				if colocated := colocatedReplicas(state, p.Replicas); len(colocated) > 0 {
+					record(failure{
+						Topic:     topic,
+						Partition: partition,
+						Category:  categoryColocated,
+						Expected:  level,
+						Replicas:  p.Replicas,
+						ISR:       p.ISR,
+						Colocated: colocated,
+					})
+				}
+			}
+
+			// record the partition if it has too few in-sync replicas to
+			// accept acks=all writes
+			if minInsync := intConfig(configs[topic], "min.insync.replicas"); *checkMinISR && len(p.ISR) < minInsync {
+				record(failure{
+					Topic:     topic,
+					Partition: partition,
+					Category:  categoryUnderMinISR,
+					Expected:  minInsync,
+					Replicas:  p.Replicas,
+					ISR:       p.ISR,
+				})
+			}
+
+			// warn about the partition if its log spans too many offsets for
+			// a compacted topic, the compaction may be lagging
+			if *maxCompactedSpan > 0 && p.Leader >= 0 && isCompacted(configs[topic]) {
+				span := spans.get(topic, partition)
+				switch {
+				case span.err != nil:
+					warns.add(log.WithFields(logrus.Fields{
+						"err":       span.err,
+						"topic":     topic,
+						"partition": partition,
+					}), "can't get the offsets of partition %s:%d", topic, partition)
+				case span.span > *maxCompactedSpan:
+					warns.add(log.WithFields(logrus.Fields{
+						"topic":     topic,
+						"partition": partition,
+						"span":      span.span,
+					}), "partition %s:%d spans %d offsets, its compaction may be lagging", topic, partition, span.span)
+				}
+			}
+
+			// record the partition if its leader disagrees with the
+			// controller on its replicas, after a reassignment
+			if lv, ok := leaderViews[p.Leader]; ok && *compareLeader && p.Leader != state.Controller {
+				if divergent := divergentViews(orig, state.Controller, topic, map[int32]*clusterState{p.Leader: lv}); divergent != nil {
+					record(failure{
+						Topic:     topic,
+						Partition: partition,
+						Category:  categoryLeaderMismatch,
+						Expected:  level,
+						Replicas:  p.Replicas,
+						ISR:       p.ISR,
+						Views:     divergent,
+					})
+				}
+			}
+
+			// record the partition if the brokers disagree on its replicas
+			if divergent := divergentViews(orig, state.Controller, topic, views); divergent != nil {
+				record(failure{
+					Topic:     topic,
+					Partition: partition,
+					Category:  categoryInconsistent,
+					Expected:  level,
+					Replicas:  p.Replicas,
+					ISR:       p.ISR,
+					Views:     divergent,
+				})
+			}
+
+			// record the partition if some of its assigned replicas are
+			// not in sync, whatever the replica level, unless they caught
+			// up within the grace period
+			if outOfSync := outOfSyncReplicas(p); *checkOutOfSync && len(outOfSync) > 0 {
+				name := partitionName(topic, partition)
+				if recovered[name] {
+					if !containsTopic(healed, name) {
+						healed = append(healed, name)
+					}
+				} else {
+					record(failure{
+						Topic:     topic,
+						Partition: partition,
+						Category:  categoryOutOfSync,
+						Expected:  len(p.Replicas),
+						Replicas:  p.Replicas,
+						ISR:       p.ISR,
+						OutOfSync: outOfSync,
+					})
+				}
+			}
+
+			// record the partition if replication not OK, unless it
+			// recovered within the grace period. A partition without a
+			// leader is always recorded as offline, its data is
+			// unavailable: leaderUnavailableIsCritical only raises its
+			// severity
+			switch category := health.PartitionCategory(p.Leader, len(replicas), level); {
+			case category == "":
+			case category != health.Offline && recovered[partitionName(topic, partition)]:
+				healed = append(healed, partitionName(topic, partition))
+			default:
+				record(failure{
+					Topic:     topic,
+					Partition: partition,
+					Category:  string(category),
+					Expected:  level,
+					Replicas:  replicas,
+					ISR:       p.ISR,
+				})
+			}
+
+			if comp != nil {
+				comp.Partitions++
+				if len(failures) > before {
+					comp.Unhealthy++
+				}
+			}
+			if tier != nil {
+				tier.Partitions++
+				if len(failures) > before {
+					tier.Unhealthy++
+				}
+			}
+		}
+		s.checkpoint.done(topic)
+
+		// time the topics, to find the slow ones
+		if *timeTopics {
+			d := topicDuration{Topic: topic, Duration: time.Since(topicStart) + spans.took[topic]}
+			durations = append(durations, d)
+			log.WithFields(logrus.Fields{
+				"topic":         topic,
+				"partitions":    len(ts.Partitions),
+				"topicDuration": d.Duration.Seconds(),
+			}).Infof("topic %s checked in %s", topic, d.Duration)
+		}
+	}
+	s.checkpoint.remove()
+	if *timeTopics {
+		logSlowestTopics(log, durations, *slowestTopics)
+	}
+
+	unhealthy := countPartitions(failures)
+	rep := &report{
+		Name:       *name,
+		ScanID:     id,
+		Cluster:    state.ClusterID,
+		Time:       start,
+		Checked:    checked,
+		Unhealthy:  unhealthy,
+		Percent:    failurePercent(unhealthy, checked),
+		Failed:     exceedsFailThreshold(unhealthy, checked, *failPercent, *failCount),
+		Total:      len(failures),
+		Failures:   failures,
+		Warnings:   warns,
+		Recovered:  healed,
+		TimedOut:   timedOut,
+		Skipped:    inconclusive,
+		Components: components,
+		Deleting:   deleting,
+		Levels:     levels,
+		Excluded:   s.excluded,
+		Probes:     probes,
+		state:      state,
+	}
+	for i := range tiers {
+		tiers[i].Passed = tiers[i].Unhealthy == 0
+		rep.Tiers = append(rep.Tiers, tiers[i])
+	}
+	// check the leaders are balanced, if asked to
+	if *maxNonPreferred >= 0 {
+		rep.Leaders = &leaderStats{
+			NonPreferred: append([]string{}, nonPreferred...),
+			Percent:      failurePercent(len(nonPreferred), led),
+			MaxPercent:   *maxNonPreferred,
+		}
+		rep.Leaders.Failed = rep.Leaders.Percent > rep.Leaders.MaxPercent
+	}
+
+	// check the replicas are evenly spread over the brokers, if asked to
+	if *maxImbalance > 0 {
+		rep.Balance = computeReplicaBalance(state, s.excluded, *maxImbalance)
+	}
+
+	// check the leadership of the hot topics is evenly spread
+	for _, topic := range splitList(*leaderTopics) {
+		l := computeTopicLeaders(state, topic, s.excluded, *maxLeaderRatio)
+		if l == nil {
+			rep.Warnings.add(log.WithFields(logrus.Fields{
+				"topic": topic,
+			}), "topic %s of leaderBalanceTopics was not scanned", topic)
+			continue
+		}
+		rep.LeaderBalance = append(rep.LeaderBalance, *l)
+	}
+
+	// check the preferred leaders of each topic are evenly spread, as
+	// intended by the assignment
+	if *maxPreferred > 0 {
+		for _, topic := range topicsList {
+			if l := computePreferredLeaders(state, topic, s.excluded, *maxPreferred); l != nil {
+				rep.Preferred = append(rep.Preferred, *l)
+			}
+		}
+	}
+
+	// compared to a baseline, only the regressions fail the check
+	if s.baseline != nil {
+		// a baseline written with -redactTopics has the topic names masked
+		diff := diffFailures(s.baseline.Failures, s.redactor.failures(failures), *baselinePolicy)
+		rep.Baseline = &diff
+		rep.Failed = len(diff.regressions()) > 0
+	}
+	// the topics being deleted only fail the check when asked to
+	if *failDeleting && len(deleting) > 0 {
+		rep.Failed = true
+	}
+	// catch the topics created or deleted behind our back
+	if *failDrift {
+		rep.TopicDrift = s.checkTopicDrift(log, state)
+		if rep.TopicDrift != nil {
+			rep.Failed = true
+		}
+	}
+	// audit the names of all the topics, whatever their health
+	if s.nameConvention != nil {
+		rep.BadNames = badTopicNames(state, s.nameConvention)
+		if *failBadName && len(rep.BadNames) > 0 {
+			rep.Failed = true
+		}
+	}
+	// audit the partitions with a single replica, whatever the replicaLevel
+	if *auditRF1 {
+		rep.SingleReplica = s.auditSingleReplica(state)
+		rep.SingleReplica.Failed = *failRF1 && rep.SingleReplica.Partitions > 0
+	}
+	for i, f := range rep.Failures {
+		rep.Failures[i].Severity = severityWarn
+		if rep.Failed && (rep.Baseline == nil || rep.Baseline.isRegression(s.redactor.failure(f))) {
+			rep.Failures[i].Severity = severityCritical
+		}
+	}
+
+	// escalate the partitions failing scan after scan
+	if *escalateAfter > 0 {
+		s.escalate(log, rep, *escalateAfter)
+	}
+
+	// follow the controller across the scans, to catch it flapping
+	s.trackController(log, rep, state, *maxCtrlChanges, *ctrlWindow)
+
+	// flag the partitions unhealthy for a while, across runs
+	if *healthFile != "" {
+		s.trackHealth(log, rep, seen)
+	}
+
+	// the partitions without a leader are critical, whatever the thresholds
+	if *leaderCritical {
+		for i, f := range rep.Failures {
+			if f.Category == categoryOffline {
+				rep.Failures[i].Severity = severityCritical
+				rep.NoLeader = append(rep.NoLeader, partitionName(f.Topic, f.Partition))
+			}
+		}
+		if len(rep.NoLeader) > 0 {
+			rep.Failed = true
+		}
+	}
+
+	// summarize the role of the targeted broker
+	if *brokerID >= 0 {
+		stats := computeBrokerStats(state, topicsList, int32(*brokerID))
+		rep.Broker = &stats
+	}
+
+	// verify the expected ACLs exist
+	if len(s.assertions) > 0 {
+		s.limiter.wait(ctx)
+		if err := interrupted(ctx, "checking ACLs"); err != nil {
+			return nil, err
+		}
+		admin, err := sarama.NewClusterAdmin(s.brokers, s.config)
+		if err != nil {
+			return nil, fmt.Errorf("error starting sarama cluster admin: %s", err)
+		}
+		rep.MissingACLs, err = checkACLs(admin, s.assertions)
+		admin.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error listing ACLs: %s", err)
+		}
+	}
+
+	// prove the data path works, which the metadata can't
+	if *canaryTopic != "" {
+		s.limiter.wait(ctx)
+		if err := interrupted(ctx, "the canary round trip"); err != nil {
+			return nil, err
+		}
+		rep.Canary = runCanary(ctx, s.client, *canaryTopic, id, *canaryTimeout)
+	}
+
+	// verify enough brokers are live, whatever the health of the topics
+	rep.LiveBrokers = state.BrokerIDs()
+	rep.Racks = state.Racks()
+	rep.MinBrokers = *minBrokers
+	rep.TooFewLive = len(rep.LiveBrokers) < *minBrokers
+
+	rep.Duration = time.Since(start).Seconds()
+	return rep, nil
+}
+
+// trackHealth updates the partitionHealthFile with the partitions checked,
+// and sets the severity of the failures of the partitions not seen healthy
+// for longer than unhealthyFor to PERSISTENT
+func (s *scanner) trackHealth(log *logrus.Entry, rep *report, checked []string) {
+	tracked, err := loadPartitionHealth(*healthFile)
+	if err != nil {
+		rep.Warnings.add(log.WithFields(logrus.Fields{
+			"err":  err,
+			"file": *healthFile,
+		}), "Error Reading Partition Health, starting over")
+	}
+	unhealthy := make(map[string]bool)
+	for _, f := range rep.Failures {
+		unhealthy[partitionName(f.Topic, f.Partition)] = true
+	}
+	persistent := tracked.update(rep.Time, checked, unhealthy, *unhealthyFor)
+	for i, f := range rep.Failures {
+		if persistent[partitionName(f.Topic, f.Partition)] {
+			rep.Failures[i].Severity = severityPersistent
+		}
+	}
+	if err := tracked.save(*healthFile); err != nil {
+		rep.Warnings.add(log.WithFields(logrus.Fields{
+			"err":  err,
+			"file": *healthFile,
+		}), "Error Writing Partition Health")
+	}
+}
+
+// badTopicNames returns the sorted names of the topics not matching the
+// convention. Kafka's internal topics are ignored
+func badTopicNames(state *clusterState, convention *regexp.Regexp) []string {
+	var bad []string
+	for _, topic := range state.TopicNames() {
+		if state.Topics[topic].Internal {
+			continue
+		}
+		if !convention.MatchString(topic) {
+			bad = append(bad, topic)
+		}
+	}
+	return bad
+}
+
+// replicaLevels returns the replica levels required, by the name of the flag
+// or tier setting them
+func (s *scanner) replicaLevels() map[string]int {
+	levels := map[string]int{"replicaLevel": *replicaLevel}
+	for _, t := range enabledInternalTopics() {
+		if *t.level > 0 {
+			levels[t.Component+"ReplicaLevel"] = *t.level
+		}
+	}
+	for _, t := range s.tiers {
+		levels["tier "+t.Name] = t.ReplicaLevel
+	}
+	return levels
+}
+
+// newScanID returns a random ID for a scan, to find all its logs
+func newScanID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// containsTopic returns true if topic is in the list
+func containsTopic(topics []string, topic string) bool {
+	for _, t := range topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// validCountMode returns true if mode is a supported replicaCountMode
+func validCountMode(mode string) bool {
+	return mode != "" && health.CountMode(mode).Valid()
+}
+
+// selected returns true if the partition is part of the scan: listed in
+// -partitions, when its topic is, and hosting a replica on -brokerID, if set
+func (s *scanner) selected(topic string, p partitionState) bool {
+	if wanted, filtered := s.partitions[topic]; filtered && !containsPartition(wanted, p.ID) {
+		return false
+	}
+	return *brokerID < 0 || containsBroker(p.Replicas, int32(*brokerID))
+}
+
+// outOfSyncReplicas returns the assigned replicas of a partition that are not
+// in its ISR. A partition without a leader has none, it is offline
+func outOfSyncReplicas(p partitionState) []int32 {
+	if p.Leader < 0 {
+		return nil
+	}
+	var outOfSync []int32
+	replicas, _ := health.Dedup(p.Replicas)
+	for _, id := range replicas {
+		if !containsBroker(p.ISR, id) {
+			outOfSync = append(outOfSync, id)
+		}
+	}
+	return outOfSync
+}
+
+// countReplicas returns the replicas of a partition that are counted against
+// the replicaLevel, depending on the replicaCountMode flag:
+// - assigned: all the replicas assigned to the partition, in sync or not
+// - isr: only the in-sync replicas
+// - live: the assigned replicas hosted on a broker that is currently alive
+func countReplicas(state *clusterState, p partitionState) []int32 {
+	return health.CountReplicas(health.CountMode(*countMode), p.Replicas, p.ISR, func(id int32) bool {
+		_, ok := state.Brokers[id]
+		return ok
+	})
+}
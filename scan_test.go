@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// entryRecorder is a logrus hook keeping the entries logged
+type entryRecorder struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+}
+
+func (r *entryRecorder) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (r *entryRecorder) Fire(e *logrus.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+// messages returns the entries logged at the level, by message
+func (r *entryRecorder) messages(level logrus.Level) map[string]*logrus.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := make(map[string]*logrus.Entry)
+	for _, e := range r.entries {
+		if e.Level == level {
+			m[e.Message] = e
+		}
+	}
+	return m
+}
+
+// newTestLogger returns a logger discarding the logs, after running its hooks
+func newTestLogger(hooks ...logrus.Hook) *logrus.Logger {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Level = logrus.DebugLevel
+	for _, h := range hooks {
+		log.AddHook(h)
+	}
+	return log
+}
+
+// newTestScanner returns a scanner of the cluster served by broker, checking
+// topics, and the recorder of its logs
+func newTestScanner(t *testing.T, broker *sarama.MockBroker, topics ...string) (*scanner, *entryRecorder) {
+	client := newTestClient(t, broker)
+	rec := &entryRecorder{}
+	return &scanner{
+		client: client,
+		config: client.Config(),
+		topics: topics,
+		log:    newTestLogger(callerHook{}, rec),
+	}, rec
+}
+
+func TestScanWarnsReplicaLevelAboveBrokers(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	meta := newTestMetadata(broker)
+	meta.AddTopicPartition("events", 0, 1, []int32{1}, []int32{1}, sarama.ErrNoError)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(meta),
+	})
+	s, _ := newTestScanner(t, broker, "events")
+	defer s.client.Close()
+
+	warning := "replicaLevel 3 is higher than the 1 live brokers, the partitions can't be fully replicated"
+	defer func(level int) { *replicaLevel = level }(*replicaLevel)
+	for level, warned := range map[int]bool{1: false, 3: true} {
+		*replicaLevel = level
+		rep, err := s.scan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := containsTopic(rep.Warnings, warning); got != warned {
+			t.Errorf("replicaLevel %d warned %v, want %v: %q", level, got, warned, rep.Warnings)
+		}
+	}
+}
+
+func TestScanCanceled(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	meta := newTestMetadata(broker)
+	// not fully replicated at a replicaLevel of 2, so the scan
+	// waits for the grace period
+	meta.AddTopicPartition("events", 0, 1, []int32{1}, []int32{1}, sarama.ErrNoError)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(meta),
+	})
+	s, _ := newTestScanner(t, broker, "events")
+	defer s.client.Close()
+
+	defer func(level int, grace time.Duration) { *replicaLevel, *isrGrace = level, grace }(*replicaLevel, *isrGrace)
+	*replicaLevel, *isrGrace = 2, 10*time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	rep, err := s.scan(ctx)
+	if took := time.Since(start); took >= *isrGrace {
+		t.Errorf("canceled scan took %s, the whole grace period", took)
+	}
+	if err == nil || !strings.Contains(err.Error(), "scan interrupted before topic events") {
+		t.Errorf("canceled scan returned %+v, %v, want it interrupted before the topic", rep, err)
+	}
+
+	// a scan canceled before it starts doesn't send any request
+	requests := len(broker.History())
+	if _, err := s.scan(ctx); err == nil || !strings.Contains(err.Error(), "scan interrupted before fetching metadata") {
+		t.Errorf("scan with a canceled context returned %v, want it interrupted before fetching metadata", err)
+	}
+	if sent := len(broker.History()) - requests; sent > 0 {
+		t.Errorf("scan with a canceled context sent %d requests", sent)
+	}
+}
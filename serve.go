@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// server runs the scans periodically and serves their results over HTTP
+type server struct {
+	ctx     context.Context // done when the process stops, interrupts the scans
+	scanner *scanner
+	log     *logrus.Logger
+
+	// scanMu serializes the scans, that share the state of the scanner. An
+	// interrupted scan holds it until it returns, the scan started after it
+	// waits for it
+	scanMu sync.Mutex
+
+	mu       sync.Mutex
+	last     *report   // report of the last successful scan
+	lastErr  error     // error of the last scan, nil if it succeeded
+	inflight *scanCall // scan currently running, if any
+	stats    stats     // counters of the scans run so far
+}
+
+// scanCall is a scan in progress. Everyone asking for a scan while it runs
+// waits for it and gets its result, instead of starting another scan. It is
+// interrupted when all of them stopped waiting
+type scanCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc // interrupts the scan
+	waiters int                // callers waiting for the result, guarded by server.mu
+	rep     *report
+	err     error
+}
+
+func newServer(ctx context.Context, s *scanner, log *logrus.Logger) *server {
+	return &server{
+		ctx:     ctx,
+		scanner: s,
+		log:     log,
+		stats:   newStats(),
+	}
+}
+
+// scan runs a scan, or joins the one already running, and waits for its
+// report. If ctx is done first, it returns the error of ctx, and the scan is
+// interrupted if nobody else waits for it. An interrupted scan is forgotten
+// right away, the next callers start a new one instead of joining it, which
+// only runs once the interrupted one returned
+func (s *server) scan(ctx context.Context) (*report, error) {
+	s.mu.Lock()
+	c := s.inflight
+	if c == nil {
+		var scanCtx context.Context
+		c = &scanCall{done: make(chan struct{})}
+		scanCtx, c.cancel = context.WithCancel(s.ctx)
+		s.inflight = c
+		go s.runScan(scanCtx, c)
+	}
+	c.waiters++
+	s.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.rep, c.err
+	case <-ctx.Done():
+		s.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			if s.inflight == c {
+				s.inflight = nil
+			}
+		}
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// runScan runs the scan of c and caches its report. A scan interrupted by
+// ctx, because the process stops or nobody waits for it anymore, is logged
+// but not recorded, the last report still stands
+func (s *server) runScan(ctx context.Context, c *scanCall) {
+	defer c.cancel()
+	s.scanMu.Lock()
+	c.rep, c.err = s.scanner.scan(ctx)
+	s.scanMu.Unlock()
+	interrupted := c.err != nil && ctx.Err() != nil
+	switch {
+	case interrupted:
+		s.log.WithFields(logrus.Fields{
+			"err": c.err,
+		}).Warn("scan interrupted")
+	case c.err != nil:
+		s.log.WithFields(logrus.Fields{
+			"err": c.err,
+		}).Error("Error Scanning Cluster")
+	default:
+		logReport(s.log, c.rep.truncate(*maxFailures))
+	}
+
+	s.mu.Lock()
+	if s.inflight == c {
+		s.inflight = nil
+	}
+	if !interrupted {
+		s.lastErr = c.err
+		s.stats.record(c.rep, c.err, *emaAlpha)
+		if c.err == nil {
+			s.last = c.rep
+		}
+	}
+	st := s.stats.snapshot()
+	s.mu.Unlock()
+	close(c.done)
+	if interrupted {
+		return
+	}
+
+	st.Reconnects = atomic.LoadInt64(&s.scanner.reconnects)
+	st.Controllers = atomic.LoadInt64(&s.scanner.flapCount)
+	exportMetrics(s.log, st)
+}
+
+// lastReport returns the report of the last successful scan, nil if there
+// was none yet
+func (s *server) lastReport() *report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// lastStatus returns the report and the error of the last scan
+func (s *server) lastStatus() (*report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, s.lastErr
+}
+
+// currentStats returns a copy of the counters
+func (s *server) currentStats() stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats.snapshot()
+	st.Reconnects = atomic.LoadInt64(&s.scanner.reconnects)
+	st.Controllers = atomic.LoadInt64(&s.scanner.flapCount)
+	return st
+}
+
+// run scans the cluster every interval, starting after offset, the first scan
+// included. It returns when the process stops
+func (s *server) run(interval, offset time.Duration) {
+	select {
+	case <-time.After(offset):
+	case <-s.ctx.Done():
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.scan(s.ctx)
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// jitterOffset returns an offset between 0 and fraction of the interval. It is
+// random but always the same for a given seed, so each instance keeps its own
+// offset across restarts
+func jitterOffset(interval time.Duration, fraction float64, seed string) time.Duration {
+	if fraction <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+	return time.Duration(r.Float64() * fraction * float64(interval))
+}
+
+// shutdownTimeout bounds the time left to the requests in progress when the
+// process stops
+const shutdownTimeout = 5 * time.Second
+
+// listenAndServe serves the HTTP endpoints on addr, until the process stops
+func (s *server) listenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleStatus)
+	mux.HandleFunc("/topic/", s.handleTopic)
+	mux.HandleFunc("/scan", s.handleScan)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/healthcheck", s.handleHealthcheck)
+	mux.HandleFunc("/stats", s.handleStats)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	s.log.WithFields(logrus.Fields{
+		"addr": addr,
+	}).Info("serving HTTP")
+	srv := &http.Server{Addr: addr, Handler: mux}
+	stopped := make(chan error, 1)
+	go func() {
+		<-s.ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		stopped <- srv.Shutdown(ctx)
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-stopped
+}
+
+// handleScan returns the report of the last scan on GET. On POST, it runs a
+// new scan right away, or joins the one already running, and returns its
+// report. A client going away stops waiting for the scan, and interrupts it if
+// nobody else waits for it
+func (s *server) handleScan(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rep := s.lastReport()
+		if rep == nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no scan completed yet"})
+			return
+		}
+		writeJSON(w, http.StatusOK, rep.truncate(*maxFailures))
+	case http.MethodPost:
+		rep, err := s.scan(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, rep.truncate(*maxFailures))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// health states of the cluster, returned by /healthz
+const (
+	statusHealthy  = "healthy"  // no failure at all
+	statusDegraded = "degraded" // only WARN failures or warnings, the check doesn't fail
+	statusCritical = "critical" // the check fails, or the cluster can't be scanned
+)
+
+// healthz is the body of /healthz
+type healthz struct {
+	Status string        `json:"status"`
+	Error  string        `json:"error,omitempty"`  // why the last scan failed
+	Topics []topicHealth `json:"topics,omitempty"` // health of each topic of the last scan
+}
+
+// topicHealth is the health of a topic in the last scan
+type topicHealth struct {
+	Topic      string    `json:"topic"`
+	Status     string    `json:"status"`     // critical with CRITICAL failures, degraded with WARN ones only
+	Partitions int       `json:"partitions"` // partitions of the topic
+	Unhealthy  int       `json:"unhealthy"`  // partitions with at least one failure
+	Failures   []failure `json:"failures,omitempty"`
+}
+
+// handleHealthz returns the health state of the cluster from the last scan:
+// healthy and degraded with a 200, critical with a 503. The body details the
+// health of each topic
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	rep, err := s.lastStatus()
+	switch {
+	case err != nil:
+		writeJSON(w, http.StatusServiceUnavailable, healthz{Status: statusCritical, Error: err.Error()})
+	case rep == nil:
+		writeJSON(w, http.StatusServiceUnavailable, healthz{Status: statusCritical, Error: "no scan completed yet"})
+	case !rep.Healthy():
+		writeJSON(w, http.StatusServiceUnavailable, healthz{Status: statusCritical, Topics: topicsHealth(rep)})
+	case rep.Total > 0 || len(rep.Warnings) > 0:
+		writeJSON(w, http.StatusOK, healthz{Status: statusDegraded, Topics: topicsHealth(rep)})
+	default:
+		writeJSON(w, http.StatusOK, healthz{Status: statusHealthy, Topics: topicsHealth(rep)})
+	}
+}
+
+// topicsHealth returns the health of the topics of the report, sorted by
+// name, like the status page
+func topicsHealth(rep *report) []topicHealth {
+	if rep.state == nil {
+		return nil
+	}
+	byTopic := make(map[string][]failure)
+	for _, f := range rep.allFailures() {
+		byTopic[f.Topic] = append(byTopic[f.Topic], f)
+	}
+	var topics []topicHealth
+	for _, name := range rep.state.TopicNames() {
+		t := topicHealth{
+			Topic:      name,
+			Status:     statusHealthy,
+			Partitions: len(rep.state.Topics[name].Partitions),
+			Unhealthy:  countPartitions(byTopic[name]),
+			Failures:   byTopic[name],
+		}
+		for _, f := range t.Failures {
+			if f.Severity != severityWarn {
+				t.Status = statusCritical
+				break
+			}
+			t.Status = statusDegraded
+		}
+		topics = append(topics, t)
+	}
+	return topics
+}
+
+// handleHealthcheck mirrors the exit code of the one-shot check for the last
+// scan, for the container healthchecks: a 200 when it would exit with 0, a 503
+// otherwise, with the summary of the scan as plain text
+func (s *server) handleHealthcheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rep, err := s.lastStatus()
+	switch {
+	case err != nil:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "error scanning the cluster: %s\n", err)
+	case rep == nil:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "no scan completed yet")
+	case exitCode(rep) != 0:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, summaryLine(rep))
+	default:
+		fmt.Fprintln(w, summaryLine(rep))
+	}
+}
+
+// handleStats returns the counters of the scans run so far
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.currentStats())
+}
+
+// writeJSON writes v as the JSON body of the response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
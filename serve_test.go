@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// newTestServer returns a server scanning a single healthy partition served
+// by a mock broker
+func newTestServer(t *testing.T, ctx context.Context) (*server, *sarama.MockBroker) {
+	broker := sarama.NewMockBroker(t, 1)
+	meta := newTestMetadata(broker)
+	meta.AddTopicPartition("events", 0, 1, []int32{1}, []int32{1}, sarama.ErrNoError)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(meta),
+	})
+	s, _ := newTestScanner(t, broker, "events")
+	return newServer(ctx, s, s.log), broker
+}
+
+// waitScans waits until the server ran n scans, or fails after timeout
+func waitScans(t *testing.T, srv *server, n int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for srv.currentStats().Scans < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d scans run after %s, want %d", srv.currentStats().Scans, timeout, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRunOffsetsFirstScan(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv, broker := newTestServer(t, ctx)
+	defer broker.Close()
+	defer srv.scanner.client.Close()
+
+	offset := 200 * time.Millisecond
+	start := time.Now()
+	go srv.run(time.Hour, offset)
+	time.Sleep(offset / 2)
+	if scans := srv.currentStats().Scans; scans != 0 {
+		t.Fatalf("%d scans run before the offset", scans)
+	}
+	waitScans(t, srv, 1, time.Second)
+	if took := time.Since(start); took < offset {
+		t.Errorf("first scan after %s, before the offset of %s", took, offset)
+	}
+}
+
+func TestScanAfterCanceledScan(t *testing.T) {
+	srv, broker := newTestServer(t, context.Background())
+	defer broker.Close()
+	defer srv.scanner.client.Close()
+
+	// the only waiter leaves while the metadata request is in flight
+	latency := 200 * time.Millisecond
+	broker.SetLatency(latency)
+	ctx, cancel := context.WithTimeout(context.Background(), latency/4)
+	defer cancel()
+	if _, err := srv.scan(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("scan returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the interrupted scan runs until its request returns, the next one
+	// waits for it instead of sharing the scanner with it
+	if srv.scanMu.TryLock() {
+		srv.scanMu.Unlock()
+		t.Fatal("the interrupted scan doesn't hold the scans lock while its request is in flight")
+	}
+
+	// the next caller doesn't join the interrupted scan
+	start := time.Now()
+	rep, err := srv.scan(context.Background())
+	if err != nil || rep == nil {
+		t.Fatalf("scan after an interrupted one returned %v, %v, want a report", rep, err)
+	}
+	// the scan only sent its request once the interrupted one got its
+	// response
+	if took := time.Since(start); took < latency+latency/2 {
+		t.Errorf("scan after an interrupted one took %s, it overlapped it", took)
+	}
+	if last := srv.lastReport(); last != rep {
+		t.Errorf("last report %p is not the one of the new scan %p", last, rep)
+	}
+}
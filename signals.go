@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleLogSignals changes the log level on signals, without restarting:
+// SIGUSR1 toggles between the configured level and debug, SIGUSR2 resets the
+// configured level
+func handleLogSignals(log *logrus.Logger, level logrus.Level) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range c {
+			next := level
+			if sig == syscall.SIGUSR1 && log.GetLevel() != logrus.DebugLevel {
+				next = logrus.DebugLevel
+			}
+			log.SetLevel(next)
+			// logged as a warning, to be visible at the default level
+			log.WithFields(logrus.Fields{
+				"signal": sig.String(),
+				"level":  next.String(),
+			}).Warn("log level changed")
+		}
+	}()
+}
+
+// cancelOnSignal returns a context canceled on the first SIGINT or SIGTERM, to
+// stop the scans cleanly. The signals are then reset, so a second one kills
+// the process right away
+func cancelOnSignal(log *logrus.Logger) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		log.WithFields(logrus.Fields{
+			"signal": sig.String(),
+		}).Warn("stopping, interrupting the scan in progress")
+		cancel()
+	}()
+	return ctx
+}
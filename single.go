@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/prune998/kafka-health/health"
+)
+
+// singleReplicas lists the partitions with a single replica assigned. Their
+// data is lost with their broker, whatever the replicaLevel
+type singleReplicas struct {
+	Topics     map[string][]int32 `json:"topics"`     // partitions with a single replica, by topic
+	Partitions int                `json:"partitions"` // total number of partitions with a single replica
+	Failed     bool               `json:"failed"`     // some partitions have a single replica, with -failOnSingleReplica
+}
+
+// auditSingleReplica lists the partitions of the snapshot with a single
+// replica assigned, once the excluded brokers are left out, whatever their
+// health. The excluded topics and the topics being deleted are ignored. It
+// returns an empty audit if there is none
+func (s *scanner) auditSingleReplica(state *clusterState) *singleReplicas {
+	audit := &singleReplicas{Topics: make(map[string][]int32)}
+	for name, ts := range state.Topics {
+		if ts.Err != "" || ts.beingDeleted() || s.excludedTopics.excludes(name) {
+			continue
+		}
+		for _, p := range ts.Partitions {
+			p, _ := withoutBrokers(p, s.excluded)
+			if replicas, _ := health.Dedup(p.Replicas); len(replicas) == 1 {
+				audit.Topics[name] = append(audit.Topics[name], p.ID)
+				audit.Partitions++
+			}
+		}
+	}
+	for _, ids := range audit.Topics {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return audit
+}
+
+// topicNames returns the sorted names of the topics with a single replica
+func (a *singleReplicas) topicNames() []string {
+	names := make([]string, 0, len(a.Topics))
+	for name := range a.Topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
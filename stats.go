@@ -0,0 +1,93 @@
+package main
+
+import "time"
+
+// stats are the operational counters of the serve mode, over the lifetime of
+// the process
+type stats struct {
+	Started       time.Time      `json:"started"`                 // when the process started
+	Uptime        float64        `json:"uptimeSeconds"`           // how long the process has been running
+	Scans         int            `json:"scans"`                   // number of scans run, successful or not
+	ScanErrors    int            `json:"scanErrors"`              // number of scans that couldn't check the cluster
+	Reconnects    int64          `json:"reconnects"`              // number of reconnections to the controller
+	Controllers   int64          `json:"controllerChanges"`       // number of changes of controller seen between two scans
+	LastScan      time.Time      `json:"lastScan"`                // when the last successful scan started
+	LastDuration  float64        `json:"lastScanDurationSeconds"` // how long the last successful scan took
+	DurationEMA   float64        `json:"scanDurationEmaSeconds"`  // exponential moving average of the scan durations
+	CanaryLatency float64        `json:"canaryLatencySeconds"`    // round trip of the canary message of the last successful scan, with -canaryTopic
+	Failures      map[string]int `json:"failures"`                // failures of the last successful scan, by category
+	FailuresTotal map[string]int `json:"failuresTotal"`           // failures of all the scans, by category
+	Unhealthy     map[string]int `json:"unhealthy"`               // unhealthy partitions of each topic of the last successful scan
+
+	failing []failure // failures of the last successful scan, for the metrics by partition
+}
+
+func newStats() stats {
+	return stats{
+		Started:       time.Now(),
+		Failures:      map[string]int{},
+		FailuresTotal: map[string]int{},
+		Unhealthy:     map[string]int{},
+	}
+}
+
+// record updates the stats with the result of a scan. alpha is the weight of
+// a successful scan in the moving average of the durations
+func (st *stats) record(rep *report, err error, alpha float64) {
+	st.Scans++
+	if err != nil {
+		st.ScanErrors++
+		return
+	}
+
+	if st.LastScan.IsZero() {
+		st.DurationEMA = rep.Duration
+	} else {
+		st.DurationEMA = alpha*rep.Duration + (1-alpha)*st.DurationEMA
+	}
+	st.LastScan = rep.Time
+	st.LastDuration = rep.Duration
+	if rep.Canary != nil {
+		st.CanaryLatency = rep.Canary.Latency
+	}
+
+	st.Failures = make(map[string]int)
+	for _, f := range rep.Failures {
+		st.Failures[f.Category]++
+		st.FailuresTotal[f.Category]++
+	}
+	st.failing = rep.Failures
+
+	st.Unhealthy = make(map[string]int)
+	if rep.state != nil {
+		for name := range rep.state.Topics {
+			st.Unhealthy[name] = 0
+		}
+	}
+	partitions := make(map[string]bool)
+	for _, f := range rep.Failures {
+		if name := partitionName(f.Topic, f.Partition); !partitions[name] {
+			partitions[name] = true
+			st.Unhealthy[f.Topic]++
+		}
+	}
+}
+
+// snapshot returns a copy of the stats, safe to use while they keep being
+// updated
+func (st *stats) snapshot() stats {
+	c := *st
+	c.Uptime = time.Since(st.Started).Seconds()
+	c.Failures = copyCounts(st.Failures)
+	c.FailuresTotal = copyCounts(st.FailuresTotal)
+	c.Unhealthy = copyCounts(st.Unhealthy)
+	return c
+}
+
+func copyCounts(m map[string]int) map[string]int {
+	c := make(map[string]int, len(m))
+	for k, n := range m {
+		c[k] = n
+	}
+	return c
+}
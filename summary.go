@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ANSI colors of the severities, used when printing to a terminal
+var severityColors = map[string]string{
+	severityOK:         "\x1b[32m", // green
+	severityWarn:       "\x1b[33m", // yellow
+	severityCritical:   "\x1b[31m", // red
+	severityPersistent: "\x1b[35m", // magenta
+}
+
+const colorReset = "\x1b[0m"
+
+// summaryTableOutput returns where -summaryTable prints the table: stdout,
+// or nil to suppress it. It is suppressed when a report is written to
+// stdout, the text one already ending with the table and the others having
+// to stay parseable, and in quiet runs, logging only the errors
+func summaryTableOutput(outputs []reportOutput, level logrus.Level) *os.File {
+	if level < logrus.WarnLevel {
+		return nil
+	}
+	for _, o := range outputs {
+		if o.path == "" {
+			return nil
+		}
+	}
+	return os.Stdout
+}
+
+// printSummaryTable writes an aligned table with the number of partitions by
+// severity, then the number of failures by category. The counts are
+// colorized by severity when color is true
+func printSummaryTable(w io.Writer, checked int, failures []failure, color bool) {
+	bySeverity := map[string]int{severityOK: checked - countPartitions(failures)}
+	byCategory := make(map[string]int)
+	partitions := make(map[string]bool)
+	for _, f := range failures {
+		byCategory[f.Category]++
+		key := fmt.Sprintf("%s:%d", f.Topic, f.Partition)
+		if !partitions[key] {
+			// a partition failing for several reasons is counted once
+			partitions[key] = true
+			bySeverity[f.Severity]++
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEVERITY\tPARTITIONS")
+	for _, severity := range []string{severityOK, severityWarn, severityCritical, severityPersistent} {
+		count := fmt.Sprint(bySeverity[severity])
+		if color && bySeverity[severity] > 0 {
+			// colors are only applied to the last column so the escape
+			// sequences don't break the alignment
+			count = severityColors[severity] + count + colorReset
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", severity, count)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\n", checked)
+
+	if len(byCategory) > 0 {
+		categories := make([]string, 0, len(byCategory))
+		for category := range byCategory {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "CATEGORY\tFAILURES")
+		for _, category := range categories {
+			fmt.Fprintf(tw, "%s\t%d\n", category, byCategory[category])
+		}
+	}
+	tw.Flush()
+}
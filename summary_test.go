@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSummaryTableOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		outputs []reportOutput
+		level   logrus.Level
+		want    *os.File
+	}{
+		{"no output", nil, logrus.WarnLevel, os.Stdout},
+		{"text to stdout", []reportOutput{{format: formatText}}, logrus.WarnLevel, nil},
+		{"json to stdout", []reportOutput{{format: formatJSON}}, logrus.WarnLevel, nil},
+		{"csv to stdout", []reportOutput{{format: formatCSV}}, logrus.InfoLevel, nil},
+		{"json to a file", []reportOutput{{format: formatJSON, path: "report.json"}}, logrus.WarnLevel, os.Stdout},
+		{"nagios to stdout, text to a file", []reportOutput{{format: formatText, path: "report.txt"}, {format: formatNagios}}, logrus.WarnLevel, nil},
+		{"quiet", nil, logrus.ErrorLevel, nil},
+		{"quiet, json to a file", []reportOutput{{format: formatJSON, path: "report.json"}}, logrus.FatalLevel, nil},
+		{"debug", nil, logrus.DebugLevel, os.Stdout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summaryTableOutput(tt.outputs, tt.level); got != tt.want {
+				t.Errorf("summaryTableOutput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteTextSummaryTable(t *testing.T) {
+	defer func(enabled bool) { *summaryTable = enabled }(*summaryTable)
+	rep := &report{Checked: 2}
+	for _, enabled := range []bool{false, true} {
+		*summaryTable = enabled
+		var buf bytes.Buffer
+		if err := writeText(&buf, rep); err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		if enabled {
+			want = 1
+		}
+		if got := strings.Count(buf.String(), "SEVERITY"); got != want {
+			t.Errorf("text report with summaryTable=%v has %d tables, want %d:\n%s", enabled, got, want, buf.String())
+		}
+	}
+}
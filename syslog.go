@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// syslogFacilities maps the names of -syslogFacility to their value
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":   syslog.LOG_KERN,
+	"user":   syslog.LOG_USER,
+	"mail":   syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON,
+	"auth":   syslog.LOG_AUTH,
+	"syslog": syslog.LOG_SYSLOG,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// syslogTimeout bounds the connection to the syslog server and each write
+const syslogTimeout = 5 * time.Second
+
+// syslogWriter sends the summary of the failing scans to syslog. The local
+// daemon is reached with the log/syslog package, a remote server with RFC 5424
+// messages over UDP or TCP. Errors are logged and never fail the check: the
+// connection is retried on the next message. A nil syslogWriter sends nothing
+type syslogWriter struct {
+	network  string // udp, tcp or unix, empty for the local daemon
+	addr     string
+	facility syslog.Priority
+	hostname string
+	log      *logrus.Logger
+
+	mu    sync.Mutex
+	local *syslog.Writer // connection to the local daemon
+	conn  net.Conn       // connection to the remote server
+}
+
+// newSyslogWriter returns a writer to addr, or nil if addr is empty. addr is
+// local for the local daemon, or network://host:port, the network being udp,
+// tcp or unix, and udp if omitted. Nothing is connected yet
+func newSyslogWriter(addr, facility string, log *logrus.Logger) (*syslogWriter, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	f, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown facility %q", facility)
+	}
+	w := &syslogWriter{facility: f, log: log}
+	if addr != "local" {
+		w.network, w.addr = "udp", addr
+		if i := strings.Index(addr, "://"); i >= 0 {
+			w.network, w.addr = addr[:i], addr[i+len("://"):]
+		}
+		switch w.network {
+		case "udp", "tcp", "unix":
+		default:
+			return nil, fmt.Errorf("unknown network %q, must be one of udp, tcp or unix", w.network)
+		}
+	}
+	w.hostname, _ = os.Hostname()
+	if w.hostname == "" {
+		w.hostname = "-"
+	}
+	return w, nil
+}
+
+// report sends the summary of a report that is not OK: as an error when it
+// fails the check, as a warning otherwise
+func (w *syslogWriter) report(rep *report) {
+	if w == nil {
+		return
+	}
+	switch reportStatus(rep) {
+	case severityCritical:
+		w.send(syslog.LOG_ERR, summaryLine(rep))
+	case severityWarn:
+		w.send(syslog.LOG_WARNING, summaryLine(rep))
+	}
+}
+
+// error sends the error of a scan that couldn't check the cluster
+func (w *syslogWriter) error(err error) {
+	if w == nil {
+		return
+	}
+	w.send(syslog.LOG_ERR, fmt.Sprintf("%s [%s]: error scanning the cluster: %s", severityCritical, *name, err))
+}
+
+func (w *syslogWriter) send(severity syslog.Priority, msg string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	if w.network == "" {
+		err = w.sendLocal(severity, msg)
+	} else {
+		err = w.sendRemote(severity, msg)
+	}
+	if err != nil {
+		w.log.WithFields(logrus.Fields{
+			"err":  err,
+			"addr": w.addr,
+		}).Warn("Error Sending To Syslog")
+	}
+}
+
+func (w *syslogWriter) sendLocal(severity syslog.Priority, msg string) error {
+	if w.local == nil {
+		local, err := syslog.New(w.facility|syslog.LOG_INFO, "kafka-health")
+		if err != nil {
+			return err
+		}
+		w.local = local
+	}
+	if severity == syslog.LOG_ERR {
+		return w.local.Err(msg)
+	}
+	return w.local.Warning(msg)
+}
+
+// sendRemote sends an RFC 5424 message. Over TCP, it is framed with its length,
+// as per RFC 6587
+func (w *syslogWriter) sendRemote(severity syslog.Priority, msg string) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.addr, syslogTimeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	line := fmt.Sprintf("<%d>1 %s %s kafka-health %d scan - %s",
+		w.facility|severity, time.Now().UTC().Format("2006-01-02T15:04:05.000000Z07:00"), w.hostname, os.Getpid(), msg)
+	if w.network == "tcp" {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := w.conn.Write([]byte(line)); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+// replicaTier is a class of topics sharing the same replica level, ex: the
+// critical topics require 5 replicas while the others require 3
+type replicaTier struct {
+	Name         string         `json:"name"`
+	ReplicaLevel int            `json:"replicaLevel"` // replica level required for the topics of the tier
+	Topics       string         `json:"topics"`       // regular expression matching the topics of the tier
+	re           *regexp.Regexp // compiled Topics
+}
+
+// tierResult summarizes the health of the topics of a tier
+type tierResult struct {
+	Name         string `json:"name"`
+	ReplicaLevel int    `json:"replicaLevel"`
+	Topics       int    `json:"topics"`     // number of topics checked
+	Partitions   int    `json:"partitions"` // number of partitions checked
+	Unhealthy    int    `json:"unhealthy"`  // number of partitions with at least one failure
+	Passed       bool   `json:"passed"`     // all the partitions of the tier are healthy
+}
+
+// loadReplicaTiers reads the tiers from a JSON file, as a list of objects with
+// the name, replicaLevel and topics keys. The order matters: a topic belongs
+// to the first tier matching it
+func loadReplicaTiers(path string) ([]replicaTier, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tiers []replicaTier
+	if err := json.Unmarshal(data, &tiers); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %s", path, err)
+	}
+	for i, t := range tiers {
+		if t.Name == "" || t.ReplicaLevel < 0 {
+			return nil, fmt.Errorf("invalid tier #%d in %s: a name and a positive replicaLevel are required", i, path)
+		}
+		tiers[i].re, err = regexp.Compile(t.Topics)
+		if err != nil {
+			return nil, fmt.Errorf("invalid topics of tier %s: %s", t.Name, err)
+		}
+	}
+	return tiers, nil
+}
+
+// findTier returns the index of the first tier matching topic, -1 if none does
+func findTier(tiers []replicaTier, topic string) int {
+	for i, t := range tiers {
+		if t.re.MatchString(topic) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// topicDuration is the time spent checking a topic
+type topicDuration struct {
+	Topic    string
+	Duration time.Duration
+}
+
+// slowTopic is a topic logged by logSlowestTopics
+type slowTopic struct {
+	Topic    string  `json:"topic"`
+	Duration float64 `json:"topicDuration"` // in seconds
+}
+
+// slowTopics are logged as a field, masked by redactHook
+type slowTopics []slowTopic
+
+func (l slowTopics) redact(r *topicRedactor) interface{} {
+	masked := make(slowTopics, len(l))
+	for i, t := range l {
+		masked[i] = slowTopic{Topic: r.topic(t.Topic), Duration: t.Duration}
+	}
+	return masked
+}
+
+// logSlowestTopics logs the n topics that took the longest to check, the
+// slowest first
+func logSlowestTopics(log *logrus.Entry, durations []topicDuration, n int) {
+	sort.SliceStable(durations, func(i, j int) bool { return durations[i].Duration > durations[j].Duration })
+	if n > 0 && len(durations) > n {
+		durations = durations[:n]
+	}
+	slowest := make(slowTopics, len(durations))
+	for i, d := range durations {
+		slowest[i] = slowTopic{Topic: d.Topic, Duration: d.Duration.Seconds()}
+	}
+	log.WithFields(logrus.Fields{
+		"slowestTopics": slowest,
+	}).Infof("%d slowest topics", len(durations))
+}
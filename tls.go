@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsVersions are the versions accepted by -tlsMinVersion
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig returns the TLS config of the connections to the brokers. The
+// minimum version and the cipher suites are Go's defaults when empty. The
+// cipher suites, by IANA name (ex: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256),
+// must be ones Go considers secure, and only apply up to TLS 1.2, as the TLS
+// 1.3 ones can't be configured. The certificates of the brokers are verified
+// against the CAs of caFile, a PEM bundle, or the system ones if empty, unless
+// skipVerify is set
+func newTLSConfig(minVersion string, cipherSuites []string, caFile string, skipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: skipVerify}
+	if caFile != "" {
+		pool, err := loadCAs(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if minVersion != "" {
+		v, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS version %q, must be one of 1.0, 1.1, 1.2 or 1.3", minVersion)
+		}
+		config.MinVersion = v
+	}
+	for _, name := range cipherSuites {
+		id, ok := cipherSuiteID(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	return config, nil
+}
+
+// loadCAs returns the pool of the CA certificates of a PEM bundle
+func loadCAs(path string) (*x509.CertPool, error) {
+	bundle, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no PEM certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+// loadClientCertificate returns the certificate presented to the brokers
+// requiring mutual TLS, or nil if none is set. The certificate and its key
+// are each read from a PEM file, or given inline as PEM, as set from the
+// environment
+func loadClientCertificate(certFile, keyFile, certPEM, keyPEM string) (*tls.Certificate, error) {
+	cert, err := readPEM("certificate", certFile, certPEM)
+	if err != nil {
+		return nil, err
+	}
+	key, err := readPEM("key", keyFile, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case cert == nil && key == nil:
+		return nil, nil
+	case cert == nil || key == nil:
+		return nil, fmt.Errorf("a client certificate needs both a certificate and its key")
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
+
+// readPEM returns the PEM of file, or the inline one, nil if neither is set
+func readPEM(what, file, inline string) ([]byte, error) {
+	switch {
+	case file != "" && inline != "":
+		return nil, fmt.Errorf("the %s is given both as a file and inline, use one of them", what)
+	case file != "":
+		return ioutil.ReadFile(file)
+	case inline != "":
+		return []byte(inline), nil
+	}
+	return nil, nil
+}
+
+// cipherSuiteID returns the ID of a secure cipher suite from its name
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, c := range tls.CipherSuites() {
+		if c.Name == name {
+			return c.ID, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// topicSummary is a line of the status page
+type topicSummary struct {
+	Name       string
+	Partitions int
+	Unhealthy  int
+}
+
+// partitionDetail is a line of the page of a topic
+type partitionDetail struct {
+	partitionState
+	Failures []failure
+}
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>kafka-health {{.Report.Name}}</title></head>
+<body>
+<h1>{{.Report.Name}}</h1>
+<p>scan {{.Report.ScanID}} of cluster {{.Report.Cluster}} at {{.Report.Time.Format "2006-01-02T15:04:05Z07:00"}}:
+{{.Report.Unhealthy}} of {{.Report.Checked}} partitions are not healthy</p>
+<table>
+<tr><th>topic</th><th>partitions</th><th>unhealthy</th></tr>
+{{range .Topics}}<tr><td><a href="/topic/{{.Name}}">{{.Name}}</a></td><td>{{.Partitions}}</td><td>{{.Unhealthy}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var topicPage = template.Must(template.New("topic").Parse(`<!DOCTYPE html>
+<html>
+<head><title>kafka-health {{.Topic}}</title></head>
+<body>
+<p><a href="/">all topics</a></p>
+<h1>{{.Topic}}</h1>
+<table>
+<tr><th>partition</th><th>leader</th><th>replicas</th><th>in-sync replicas</th><th>offline replicas</th><th>failures</th></tr>
+{{range .Partitions}}<tr><td>{{.ID}}</td><td>{{.Leader}}</td><td>{{.Replicas}}</td><td>{{.ISR}}</td><td>{{.Offline}}</td><td>{{range .Failures}}{{.Severity}} {{.Category}} {{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// handleStatus renders the status page: the topics of the last scan, with
+// their number of unhealthy partitions
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	rep := s.lastReport()
+	if rep == nil || rep.state == nil {
+		http.Error(w, "no scan completed yet", http.StatusServiceUnavailable)
+		return
+	}
+	unhealthy := make(map[string]map[int32]bool)
+	for _, f := range rep.Failures {
+		if unhealthy[f.Topic] == nil {
+			unhealthy[f.Topic] = make(map[int32]bool)
+		}
+		unhealthy[f.Topic][f.Partition] = true
+	}
+	var topics []topicSummary
+	for _, name := range rep.state.TopicNames() {
+		topics = append(topics, topicSummary{
+			Name:       name,
+			Partitions: len(rep.state.Topics[name].Partitions),
+			Unhealthy:  len(unhealthy[name]),
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	statusPage.Execute(w, struct {
+		Report *report
+		Topics []topicSummary
+	}{rep, topics})
+}
+
+// handleTopic renders the page of a topic, /topic/{name}: the leader,
+// replicas and in-sync replicas of each of its partitions in the last scan,
+// with their failures
+func (s *server) handleTopic(w http.ResponseWriter, r *http.Request) {
+	rep := s.lastReport()
+	if rep == nil || rep.state == nil {
+		http.Error(w, "no scan completed yet", http.StatusServiceUnavailable)
+		return
+	}
+	topic := strings.TrimPrefix(r.URL.Path, "/topic/")
+	ts, ok := rep.state.Topics[topic]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	partitions := make([]partitionDetail, len(ts.Partitions))
+	for i, p := range ts.Partitions {
+		partitions[i].partitionState = p
+		for _, f := range rep.Failures {
+			if f.Topic == topic && f.Partition == p.ID {
+				partitions[i].Failures = append(partitions[i].Failures, f)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	topicPage.Execute(w, struct {
+		Topic      string
+		Partitions []partitionDetail
+	}{topic, partitions})
+}
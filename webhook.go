@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// types of the CloudEvents posted to the -webhookURL
+const (
+	eventFailure = "com.kafka-health.failure" // a failing partition, as found in the report
+	eventScan    = "com.kafka-health.scan"    // the report of a scan, without its failures
+	eventError   = "com.kafka-health.error"   // a scan that couldn't check the cluster
+)
+
+// webhookTimeout bounds each post to the webhook
+const webhookTimeout = 10 * time.Second
+
+// cloudEvent is a CloudEvents 1.0 event, in the structured JSON mode
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"` // topic:partition of a failure
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// webhook posts the results of the scans to a URL as CloudEvents, for the
+// event driven alerting. The errors are logged and never fail the check. A
+// nil webhook posts nothing
+type webhook struct {
+	url     string
+	retries int           // number of retries of an event on a server error
+	backoff time.Duration // wait before the first retry, doubled on each retry
+	client  *http.Client
+	log     *logrus.Logger
+}
+
+// newWebhook returns a webhook posting to url, or nil if url is empty
+func newWebhook(url string, retries int, backoff time.Duration, log *logrus.Logger) *webhook {
+	if url == "" {
+		return nil
+	}
+	return &webhook{
+		url:     url,
+		retries: retries,
+		backoff: backoff,
+		client:  &http.Client{Timeout: webhookTimeout},
+		log:     log,
+	}
+}
+
+// report posts an event for each failure of the report, up to
+// -maxFailuresToReport, then an event with the report itself
+func (h *webhook) report(rep *report) {
+	if h == nil {
+		return
+	}
+	rep = rep.truncate(*maxFailures)
+	events := make([]cloudEvent, 0, len(rep.Failures)+1)
+	for i, f := range rep.Failures {
+		e := h.event(fmt.Sprintf("%s-%d", rep.ScanID, i), eventFailure, rep.Time, f)
+		e.Subject = partitionName(f.Topic, f.Partition)
+		events = append(events, e)
+	}
+	summary := *rep
+	summary.Failures = nil
+	events = append(events, h.event(rep.ScanID, eventScan, rep.Time, &summary))
+	h.send(events)
+}
+
+// error posts an event for a scan that couldn't check the cluster
+func (h *webhook) error(err error) {
+	if h == nil {
+		return
+	}
+	h.send([]cloudEvent{h.event(newScanID(), eventError, time.Now(), map[string]string{"error": err.Error()})})
+}
+
+// event returns an event from the health check, named after it
+func (h *webhook) event(id, typ string, t time.Time, data interface{}) cloudEvent {
+	return cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          *name,
+		Type:            typ,
+		Time:            t,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// send posts the events in order. Once an event can't be posted, the next
+// ones are dropped, as the webhook is likely down
+func (h *webhook) send(events []cloudEvent) {
+	for i, e := range events {
+		if err := h.post(e); err != nil {
+			h.log.WithFields(logrus.Fields{
+				"err":     err,
+				"url":     h.url,
+				"type":    e.Type,
+				"dropped": len(events) - i,
+			}).Warn("Error Posting To Webhook")
+			return
+		}
+	}
+}
+
+// post posts an event, retrying with an exponential backoff on the network
+// errors and the server errors. The client errors are not retried
+func (h *webhook) post(e cloudEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	backoff := h.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := h.postOnce(body)
+		if err == nil || !retry || attempt >= h.retries {
+			return err
+		}
+		h.log.WithFields(logrus.Fields{
+			"err":     err,
+			"url":     h.url,
+			"attempt": attempt + 1,
+			"backoff": backoff.String(),
+		}).Debug("retrying the post to the webhook")
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postOnce posts the body of an event, and returns whether it is worth
+// retrying when it fails
+func (h *webhook) postOnce(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return resp.StatusCode >= 500, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}